@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Packages: AppImages for linux targets, built with appimagetool
+   ------------------------------------------------------------------ */
+
+// appImageArch is the ARCH appimagetool expects for a GOARCH.
+var appImageArch = map[string]string{"amd64": "x86_64", "arm64": "aarch64", "arm": "armhf", "386": "i686"}
+
+// appRun starts the binary from wherever the AppImage is mounted.
+const appRun = `#!/bin/sh
+HERE="$(dirname "$(readlink -f "$0")")"
+exec "$HERE/usr/bin/%s" "$@"
+`
+
+// desktopEntry is a generated .desktop file, for packages that have no
+// own one.
+func desktopEntry(name, exec, icon, comment string, categories []string, terminal bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Desktop Entry]\nType=Application\nName=%s\nExec=%s\nIcon=%s\n", name, exec, icon)
+	if comment != "" {
+		fmt.Fprintf(&b, "Comment=%s\n", comment)
+	}
+	if len(categories) == 0 {
+		categories = []string{"Utility"}
+	}
+	fmt.Fprintf(&b, "Categories=%s;\nTerminal=%t\n", strings.Join(categories, ";"), terminal)
+	return b.String()
+}
+
+// writeAppDir lays out the AppDir of job j in dir: AppRun, the desktop
+// file and icon at the top, the binary in usr/bin.
+func writeAppDir(cfg *Config, j buildJob, dir string) error {
+	a := cfg.Packages.AppImage
+	bin := binaryName(j.Cfg)
+	name := firstNonEmpty(a.Name, packageName(cfg, j))
+	icon := strings.TrimSuffix(filepath.Base(a.Icon), filepath.Ext(a.Icon))
+	copyTo := func(src, dst string, mode os.FileMode) error {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("packages.appimage: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, b, mode)
+	}
+	if err := copyTo(j.Out, filepath.Join(dir, "usr", "bin", bin), 0o755); err != nil {
+		return err
+	}
+	if err := copyTo(a.Icon, filepath.Join(dir, filepath.Base(a.Icon)), 0o644); err != nil {
+		return err
+	}
+	desktop := filepath.Join(dir, packageName(cfg, j)+".desktop")
+	if a.Desktop != "" {
+		if err := copyTo(a.Desktop, desktop, 0o644); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(desktop, []byte(desktopEntry(name, bin, icon, cfg.Packages.Description, a.Categories, !a.GUI)), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "AppRun"), []byte(fmt.Sprintf(appRun, bin)), 0o755)
+}
+
+// buildAppImages packs every linux binary into an AppImage with
+// appimagetool, written next to the binary.
+func buildAppImages(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	a := cfg.Packages.AppImage
+	if a == nil {
+		return nil
+	}
+	if a.Icon == "" {
+		return fmt.Errorf("packages.appimage.icon is required (a .png or .svg)")
+	}
+	var todo []buildJob
+	for _, j := range packageJobs(jobs, "linux") {
+		if appImageArch[j.Target.Arch] == "" {
+			fmt.Fprintf(textOut, "⚠ %s: no AppImage for %s\n", j.label(), j.Target.Arch)
+			continue
+		}
+		todo = append(todo, j)
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: appimagetool")
+		for _, j := range todo {
+			fmt.Fprintf(textOut, "ARCH=%s appimagetool <AppDir> %s\n", appImageArch[j.Target.Arch], packageFile(cfg, j, j.Target.Arch, "AppImage"))
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("appimagetool"); err != nil {
+		return fmt.Errorf("packages.appimage: appimagetool not found in PATH (https://github.com/AppImage/appimagetool/releases)")
+	}
+
+	fmt.Fprintln(textOut, ">>> AppImages")
+	for _, j := range todo {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		if err := appImageJob(cfg, j, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appImageJob builds the AppImage of job j in a temporary AppDir.
+func appImageJob(cfg *Config, j buildJob, m *Manifest) error {
+	dir, err := os.MkdirTemp("", "go-builder-appdir-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := writeAppDir(cfg, j, dir); err != nil {
+		return err
+	}
+	out := packageFile(cfg, j, j.Target.Arch, "AppImage")
+	cmd := exec.Command("appimagetool", "--no-appstream", dir, out)
+	// appimagetool is itself an AppImage; containers rarely have FUSE
+	cmd.Env = append(os.Environ(), "ARCH="+appImageArch[j.Target.Arch], "APPIMAGE_EXTRACT_AND_RUN=1")
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("appimagetool %s: %v: %s", j.label(), err, strings.TrimSpace(string(b)))
+	}
+	return recordPackage(m, j, "appimage", out)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Pre-build asset steps (npm, protoc, codegen …) with change tracking
+   ------------------------------------------------------------------ */
+
+// assetStampDir holds one input hash per asset step.
+const assetStampDir = ".assets"
+
+// runAssets executes every asset step whose inputs changed since the last
+// successful run or whose outputs are missing.
+func runAssets(cfg *Config, env []string, dry bool) error {
+	for _, a := range cfg.Assets {
+		if a.Name == "" || len(a.Run) == 0 {
+			return fmt.Errorf("assets: each step needs a name and run commands")
+		}
+		if dry {
+			fmt.Fprintf(textOut, "\n# Dry-run: asset %s\n%s\n", a.Name, strings.Join(a.Run, "\n"))
+			continue
+		}
+
+		sum, err := assetHash(a)
+		if err != nil {
+			return fmt.Errorf("asset %s: %w", a.Name, err)
+		}
+		stamp := filepath.Join(cfg.BuildDir, assetStampDir, a.Name+".sha256")
+		if prev, err := os.ReadFile(stamp); err == nil && string(prev) == sum && len(a.Inputs) > 0 {
+			if ok, _ := outputsPresent(a.Outputs); ok {
+				fmt.Fprintf(textOut, ">>> Asset %s up to date\n", a.Name)
+				continue
+			}
+		}
+
+		fmt.Fprintf(textOut, ">>> Asset %s\n", a.Name)
+		for _, line := range a.Run {
+			if err := shellExec(line, a.Dir, env); err != nil {
+				return fmt.Errorf("asset %s: %q: %w", a.Name, line, err)
+			}
+		}
+		if ok, err := outputsPresent(a.Outputs); err != nil || !ok {
+			return fmt.Errorf("asset %s: outputs %v not produced", a.Name, a.Outputs)
+		}
+		if err := os.MkdirAll(filepath.Dir(stamp), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(stamp, []byte(sum), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assetHash digests the run commands plus the path and content of every
+// input file, so editing either the step or its sources re-triggers it.
+func assetHash(a AssetStep) (string, error) {
+	files, err := globFiles(a.Inputs)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "dir=%s\n", a.Dir)
+	for _, l := range a.Run {
+		fmt.Fprintf(h, "run=%s\n", l)
+	}
+	for _, f := range files {
+		sum, _, err := fileSHA256(f)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", sum, filepath.ToSlash(f))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// outputsPresent reports whether every output pattern matches a file.
+func outputsPresent(patterns []string) (bool, error) {
+	for _, p := range patterns {
+		m, err := globFiles([]string{p})
+		if err != nil || len(m) == 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// shellExec runs line through the platform shell in dir.
+func shellExec(line, dir string, env []string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", line)
+	} else {
+		cmd = exec.Command("sh", "-c", line)
+	}
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = textOut, os.Stderr
+	return cmd.Run()
+}
+
+// globFiles expands slash-separated patterns, where "**" matches any
+// number of directories, into a sorted list of regular files.
+func globFiles(patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, pat := range patterns {
+		pat = filepath.ToSlash(pat)
+		root := globRoot(pat)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if matchGlob(pat, filepath.ToSlash(p)) {
+				seen[p] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for p := range seen {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// globRoot is the literal directory prefix of pat (where walking starts).
+func globRoot(pat string) string {
+	segs := strings.Split(pat, "/")
+	i := 0
+	for ; i < len(segs)-1; i++ {
+		if strings.ContainsAny(segs[i], "*?[") {
+			break
+		}
+	}
+	if i == 0 {
+		if strings.HasPrefix(pat, "/") {
+			return "/"
+		}
+		return "."
+	}
+	return filepath.FromSlash(strings.Join(segs[:i], "/"))
+}
+
+// matchGlob matches name against pat segment by segment; "**" spans
+// zero or more segments.
+func matchGlob(pat, name string) bool {
+	return matchSegs(strings.Split(path.Clean(pat), "/"), strings.Split(path.Clean(name), "/"))
+}
+
+func matchSegs(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegs(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
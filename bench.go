@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Benchmark regression gate: run benchmarks, keep history, compare
+   ------------------------------------------------------------------ */
+
+const benchHistoryDir = ".bench"
+
+// benchRun is one stored benchmark run: ns/op samples per benchmark.
+type benchRun struct {
+	Time    time.Time            `json:"time"`
+	Samples map[string][]float64 `json:"samples"`
+}
+
+// runBenchGate runs the configured benchmarks, stores the results and
+// compares them with the previous run in the history directory.
+func runBenchGate(cfg *Config, env []string, dry bool) error {
+	b := cfg.Bench
+	args := []string{"test", "-run", "^$", "-bench", firstNonEmpty(b.Run, "."), "-count", strconv.Itoa(max(b.Count, 1))}
+	if b.BenchTime != "" {
+		args = append(args, "-benchtime", b.BenchTime)
+	}
+	pkgs := b.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	args = append(args, pkgs...)
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: benchmarks\ngo %s\n", strings.Join(args, " "))
+		return nil
+	}
+
+	fmt.Fprintln(textOut, ">>> Benchmarks")
+	var buf bytes.Buffer
+	cmd := exec.Command(goBin, args...)
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = io.MultiWriter(textOut, &buf), os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("benchmarks: %w", err)
+	}
+	cur := benchRun{Time: time.Now().UTC(), Samples: parseBench(&buf)}
+
+	dir := firstNonEmpty(b.History, filepath.Join(cfg.BuildDir, benchHistoryDir))
+	prev, err := lastBenchRun(dir)
+	if err != nil {
+		return err
+	}
+	if err := saveBenchRun(dir, cur); err != nil {
+		return err
+	}
+	if prev == nil {
+		fmt.Fprintln(textOut, "no benchmark history yet; baseline recorded")
+		return nil
+	}
+
+	threshold := b.Threshold
+	if threshold == 0 {
+		threshold = 5
+	}
+	var regressed []string
+	fmt.Fprintf(textOut, "\n%-40s %12s %12s %8s %6s\n", "benchmark", "old ns/op", "new ns/op", "delta", "p")
+	for _, name := range sortedKeys(cur.Samples) {
+		old, ok := prev.Samples[name]
+		if !ok {
+			continue
+		}
+		o, n := mean(old), mean(cur.Samples[name])
+		delta := 100 * (n - o) / o
+		p := mannWhitneyP(old, cur.Samples[name])
+		mark := ""
+		if p < 0.05 && delta > threshold {
+			mark = "  REGRESSION"
+			regressed = append(regressed, name)
+		}
+		fmt.Fprintf(textOut, "%-40s %12.1f %12.1f %+7.1f%% %6.3f%s\n", name, o, n, delta, p, mark)
+	}
+	if len(regressed) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("%d benchmark(s) regressed more than %.1f%%: %s", len(regressed), threshold, strings.Join(regressed, ", "))
+	if b.Mode == "warn" {
+		fmt.Fprintln(textOut, "warning: "+msg)
+		return nil
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// parseBench extracts ns/op samples from `go test -bench` output. Names
+// are prefixed with their package and lose the -GOMAXPROCS suffix.
+func parseBench(r io.Reader) map[string][]float64 {
+	out := map[string][]float64{}
+	pkg := ""
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) == 2 && f[0] == "pkg:" {
+			pkg = f[1]
+			continue
+		}
+		if len(f) < 4 || !strings.HasPrefix(f[0], "Benchmark") {
+			continue
+		}
+		for i := 2; i+1 < len(f); i += 2 {
+			if f[i+1] != "ns/op" {
+				continue
+			}
+			v, err := strconv.ParseFloat(f[i], 64)
+			if err != nil {
+				break
+			}
+			name := f[0]
+			if j := strings.LastIndexByte(name, '-'); j > 0 {
+				if _, err := strconv.Atoi(name[j+1:]); err == nil {
+					name = name[:j]
+				}
+			}
+			if pkg != "" {
+				name = pkg + "." + name
+			}
+			out[name] = append(out[name], v)
+		}
+	}
+	return out
+}
+
+func lastBenchRun(dir string) (*benchRun, error) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files) // timestamped names sort chronologically
+	b, err := os.ReadFile(files[len(files)-1])
+	if err != nil {
+		return nil, err
+	}
+	var r benchRun
+	return &r, json.Unmarshal(b, &r)
+}
+
+func saveBenchRun(dir string, r benchRun) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, r.Time.Format("20060102T150405Z")+".json"), b, 0o644)
+}
+
+func mean(xs []float64) float64 {
+	s := 0.0
+	for _, x := range xs {
+		s += x
+	}
+	return s / float64(len(xs))
+}
+
+// mannWhitneyP is the two-sided p-value of the Mann-Whitney U test (normal
+// approximation with tie correction), the test benchstat uses to decide
+// whether two sample sets differ.
+func mannWhitneyP(a, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 < 2 || n2 < 2 {
+		return 1
+	}
+	type obs struct {
+		v     float64
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	rankA, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // average of ranks i+1 … j
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rankA - n1*(n1+1)/2
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-n1*n2/2) - 0.5) / sigma
+	return math.Erfc(math.Max(z, 0) / math.Sqrt2)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
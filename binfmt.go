@@ -0,0 +1,382 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Native executable inspection (ELF / PE / Mach-O) without `file`
+   ------------------------------------------------------------------ */
+
+// binInfo summarises an executable's header.
+type binInfo struct {
+	Format   string   // elf | pe | macho
+	Arch     string   // GOARCH spelling where known
+	Static   bool     // no dynamic loader / imported libraries
+	Libs     []string // DT_NEEDED, PE imports or LC_LOAD_DYLIB
+	SymTab   bool     // a (non-dynamic) symbol table is present
+	Sections []binSection
+}
+
+type binSection struct {
+	Name string
+	Size uint64
+}
+
+// readBinary detects the format of path and parses its header.
+func readBinary(path string) (*binInfo, error) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		return elfInfo(f)
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		return peInfo(f)
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		return machoInfo(f)
+	}
+	if fat, err := macho.OpenFat(path); err == nil {
+		defer fat.Close()
+		return machoInfo(fat.Arches[0].File)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return nil, errors.New(path + ": not an ELF, PE or Mach-O executable")
+}
+
+func elfInfo(f *elf.File) (*binInfo, error) {
+	info := &binInfo{Format: "elf", Arch: elfArch[f.Machine]}
+	switch {
+	case f.Machine == elf.EM_MIPS && f.Class == elf.ELFCLASS64:
+		info.Arch = "mips64"
+	case f.Machine == elf.EM_PPC64 && f.ByteOrder == binary.LittleEndian:
+		info.Arch = "ppc64le"
+	}
+	if f.Machine == elf.EM_MIPS && f.ByteOrder == binary.LittleEndian {
+		info.Arch += "le"
+	}
+	libs, err := f.ImportedLibraries()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+	info.Libs = libs
+	interp := false
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			interp = true
+		}
+	}
+	info.Static = !interp && len(libs) == 0
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_SYMTAB {
+			info.SymTab = true
+		}
+		if s.Name != "" && s.Type != elf.SHT_NOBITS {
+			info.Sections = append(info.Sections, binSection{s.Name, s.Size})
+		}
+	}
+	return info, nil
+}
+
+func peInfo(f *pe.File) (*binInfo, error) {
+	info := &binInfo{Format: "pe", Arch: peArch[f.Machine], SymTab: f.NumberOfSymbols > 0}
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	info.Libs = libs
+	// Windows always links kernel32 etc.; "static" means no third-party DLLs.
+	info.Static = true
+	for _, l := range libs {
+		if !windowsSystemDLL(l) {
+			info.Static = false
+		}
+	}
+	for _, s := range f.Sections {
+		info.Sections = append(info.Sections, binSection{s.Name, uint64(s.Size)})
+	}
+	return info, nil
+}
+
+func machoInfo(f *macho.File) (*binInfo, error) {
+	info := &binInfo{Format: "macho", Arch: machoArch[f.Cpu]}
+	libs, err := f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	info.Libs = libs
+	info.Static = len(libs) == 0
+	for _, s := range f.Sections {
+		info.Sections = append(info.Sections, binSection{s.Seg + "," + s.Name, s.Size})
+	}
+	return info, nil
+}
+
+// debugSections lists the DWARF sections (.debug_*, .zdebug_*, Mach-O
+// __DWARF) still present in the binary.
+func (b *binInfo) debugSections() []string {
+	var out []string
+	for _, s := range b.Sections {
+		name := s.Name
+		if b.Format == "macho" {
+			seg, sect, _ := strings.Cut(name, ",")
+			if seg == "__DWARF" {
+				out = append(out, name)
+			}
+			name = sect
+		}
+		if strings.HasPrefix(name, ".debug_") || strings.HasPrefix(name, ".zdebug_") {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// sectionsBySize returns sections sorted largest first.
+func (b *binInfo) sectionsBySize() []binSection {
+	out := append([]binSection(nil), b.Sections...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	return out
+}
+
+func (b *binInfo) linkage() string {
+	if b.Static {
+		return "static"
+	}
+	return fmt.Sprintf("dynamic (%d libraries)", len(b.Libs))
+}
+
+var windowsSystemDLLs = map[string]bool{
+	"kernel32.dll": true, "ntdll.dll": true, "advapi32.dll": true, "ws2_32.dll": true,
+	"winmm.dll": true, "user32.dll": true, "shell32.dll": true, "ole32.dll": true,
+	"crypt32.dll": true, "bcrypt.dll": true, "secur32.dll": true, "iphlpapi.dll": true,
+	"userenv.dll": true, "netapi32.dll": true, "dbghelp.dll": true, "powrprof.dll": true,
+}
+
+func windowsSystemDLL(name string) bool {
+	return windowsSystemDLLs[strings.ToLower(name)]
+}
+
+var elfArch = map[elf.Machine]string{
+	elf.EM_386: "386", elf.EM_X86_64: "amd64", elf.EM_ARM: "arm", elf.EM_AARCH64: "arm64",
+	elf.EM_MIPS: "mips", elf.EM_PPC64: "ppc64", elf.EM_RISCV: "riscv64", elf.EM_S390: "s390x",
+	elf.EM_LOONGARCH: "loong64",
+}
+
+var peArch = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386: "386", pe.IMAGE_FILE_MACHINE_AMD64: "amd64",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm", pe.IMAGE_FILE_MACHINE_ARM64: "arm64",
+}
+
+var machoArch = map[macho.Cpu]string{
+	macho.Cpu386: "386", macho.CpuAmd64: "amd64", macho.CpuArm: "arm", macho.CpuArm64: "arm64",
+}
+
+// assertArch checks that path was built for goos/goarch: the container
+// format must match the OS (PE for windows, Mach-O for darwin/ios, ELF
+// elsewhere) and the machine type must match the architecture.
+func assertArch(path, goos, goarch string, w io.Writer, dry bool) error {
+	if dry {
+		fmt.Fprintf(w, "# Dry-run: verifying %s is %s/%s\n", path, goos, goarch)
+		return nil
+	}
+	bin, err := readBinary(path)
+	if err != nil {
+		return fmt.Errorf("arch check failed: %w", err)
+	}
+	want := "elf"
+	switch goos {
+	case "windows":
+		want = "pe"
+	case "darwin", "ios":
+		want = "macho"
+	}
+	if bin.Format != want || bin.Arch != goarch {
+		got := firstNonEmpty(bin.Arch, "unknown arch")
+		return fmt.Errorf("%s is %s/%s, want %s/%s", path, bin.Format, got, want, goarch)
+	}
+	return nil
+}
+
+// assertStripped fails if path still carries a symbol table or DWARF.
+func assertStripped(path string, w io.Writer, dry bool) error {
+	if dry {
+		fmt.Fprintf(w, "# Dry-run: verifying %s is stripped\n", path)
+		return nil
+	}
+	bin, err := readBinary(path)
+	if err != nil {
+		return fmt.Errorf("strip check failed: %w", err)
+	}
+	var found []string
+	if bin.SymTab {
+		found = append(found, "symbol table")
+	}
+	if dbg := bin.debugSections(); len(dbg) > 0 {
+		found = append(found, fmt.Sprintf("%d DWARF sections", len(dbg)))
+	}
+	if len(found) > 0 {
+		return fmt.Errorf("%s is NOT stripped: %s (set build.strip: true)", path, strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// assertLibs checks that every shared library path needs is allowed. An
+// allow entry matches a library by name, as a path.Match pattern, or as a
+// prefix of a versioned soname ("libc" allows libc.so.6).
+func assertLibs(path string, allow []string, w io.Writer, dry bool) error {
+	if dry {
+		fmt.Fprintf(w, "# Dry-run: verifying %s only links %s\n", path, strings.Join(allow, ", "))
+		return nil
+	}
+	bin, err := readBinary(path)
+	if err != nil {
+		return fmt.Errorf("library check failed: %w", err)
+	}
+	var bad []string
+	for _, lib := range bin.Libs {
+		if !libAllowed(lib, allow) {
+			bad = append(bad, lib)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("%s links libraries outside verify_dynamic_libs: %s", path, strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+func libAllowed(lib string, allow []string) bool {
+	name := filepath.Base(lib) // Mach-O records install paths
+	for _, a := range allow {
+		if ok, _ := filepath.Match(a, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(a, lib); ok {
+			return true
+		}
+		for _, ext := range []string{".so", ".dylib", ".dll"} {
+			if strings.HasPrefix(name, a+ext) || strings.HasPrefix(name, a+".") && strings.HasSuffix(name, ext) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assertGlibc fails if the ELF file at path references a GLIBC_x.y symbol
+// version newer than max. Static and non-ELF outputs pass trivially.
+func assertGlibc(path, max string, w io.Writer, dry bool) error {
+	limit, ok := parseGlibc("GLIBC_" + strings.TrimPrefix(max, "GLIBC_"))
+	if !ok {
+		return fmt.Errorf("max_glibc: want a version like 2.17, got %q", max)
+	}
+	if dry {
+		fmt.Fprintf(w, "# Dry-run: verifying %s needs at most glibc %s\n", path, max)
+		return nil
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	syms, err := f.ImportedSymbols()
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return fmt.Errorf("glibc check failed: %w", err)
+	}
+	var newer []string
+	newest, top := limit, ""
+	for _, s := range syms {
+		v, ok := parseGlibc(s.Version)
+		if !ok || !glibcLess(limit, v) {
+			continue
+		}
+		newer = append(newer, s.Name+"@"+s.Version)
+		if glibcLess(newest, v) {
+			newest, top = v, s.Version
+		}
+	}
+	if len(newer) == 0 {
+		return nil
+	}
+	sort.Strings(newer)
+	if len(newer) > 8 {
+		newer = append(newer[:8], fmt.Sprintf("… %d more", len(newer)-8))
+	}
+	return fmt.Errorf("%s needs %s, max_glibc is %s: %s", path, top, max, strings.Join(newer, ", "))
+}
+
+// parseGlibc reads "GLIBC_2.17" or "GLIBC_2.3.4"; GLIBC_PRIVATE and other
+// version names are not glibc releases.
+func parseGlibc(s string) ([]int, bool) {
+	rest, ok := strings.CutPrefix(s, "GLIBC_")
+	if !ok {
+		return nil, false
+	}
+	var v []int
+	for _, p := range strings.Split(rest, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, false
+		}
+		v = append(v, n)
+	}
+	return v, true
+}
+
+func glibcLess(a, b []int) bool {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// stripELF runs objcopy --strip-all on an ELF file; other formats are
+// left alone. $OBJCOPY wins, then objcopy, then llvm-objcopy.
+func stripELF(path string, env map[string]string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil
+	}
+	f.Close()
+	tool := env["OBJCOPY"]
+	for _, c := range []string{"objcopy", "llvm-objcopy"} {
+		if tool != "" {
+			break
+		}
+		if _, err := exec.LookPath(c); err == nil {
+			tool = c
+		}
+	}
+	if tool == "" {
+		return errors.New("strip_objcopy: neither objcopy nor llvm-objcopy found (set OBJCOPY)")
+	}
+	if out, err := exec.Command(tool, "--strip-all", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s --strip-all %s: %v\n%s", tool, path, err, out)
+	}
+	return nil
+}
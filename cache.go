@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   go-builder caches and the `cache ls|prune` subcommand
+   ------------------------------------------------------------------ */
+
+// volumePrefix names every container volume go-builder creates.
+const volumePrefix = "go-builder-"
+
+// Persistent caches mounted into build containers.
+const (
+	modCacheVolume         = volumePrefix + "modcache"
+	modCacheContainerDir   = "/go/pkg/mod"
+	buildCacheVolume       = volumePrefix + "gocache"
+	buildCacheContainerDir = "/go-builder-gocache"
+)
+
+// cacheMount is the -v value for a docker cache setting: "true" → the
+// named volume, a path → that host directory (created if missing),
+// "" or "false" → no mount. Under podman's keep-id the volume gets :U so
+// podman chowns it to the mapped user.
+func cacheMount(setting, volume, dst, label string, rt containerRuntime, keepID, dry bool) (string, error) {
+	switch setting {
+	case "", "false":
+		return "", nil
+	case "true":
+		if keepID {
+			return volume + ":" + dst + ":U", nil
+		}
+		return volume + ":" + dst, nil // volumes are labelled by the runtime
+	}
+	dir, err := filepath.Abs(setting)
+	if err != nil {
+		return "", err
+	}
+	if !dry {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return "", err
+		}
+	}
+	if dir, err = hostMountPath(dir, rt.Bin()); err != nil {
+		return "", err
+	}
+	if label != "" {
+		return dir + ":" + dst + ":" + label, nil
+	}
+	return dir + ":" + dst, nil
+}
+
+// purgeCacheMounts empties the docker caches configured for this project
+// (--purge-cache) so the next containerised build starts cold.
+func purgeCacheMounts(cfg *Config, rt containerRuntime, dry bool) error {
+	caches := []struct{ setting, volume string }{
+		{cfg.Docker.CacheModules, modCacheVolume},
+		{cfg.Docker.CacheBuild, buildCacheVolume},
+	}
+	for _, c := range caches {
+		var target string
+		switch c.setting {
+		case "", "false":
+			continue
+		case "true":
+			target = c.volume
+		default:
+			target = c.setting
+		}
+		if dry {
+			fmt.Printf("# Dry-run: remove cache %s\n", target)
+			continue
+		}
+		var err error
+		if c.setting == "true" {
+			err = exec.Command(rt.Bin(), "volume", "rm", "-f", target).Run()
+		} else {
+			err = os.RemoveAll(target)
+		}
+		if err != nil {
+			return fmt.Errorf("purge %s: %w", target, err)
+		}
+		fmt.Printf("purged cache %s\n", target)
+	}
+	return nil
+}
+
+// cacheRoot is the per-user cache directory shared by all projects.
+func cacheRoot() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "go-builder")
+}
+
+// cacheDir returns a named subdirectory of cacheRoot (toolchains, tools …).
+func cacheDir(kind string) string {
+	return filepath.Join(cacheRoot(), kind)
+}
+
+// cacheEntry is one prunable cache location.
+type cacheEntry struct {
+	Kind string // toolchains | tools | modproxy | assets | volumes
+	Name string // path or volume name
+	Size int64  // -1 when unknown
+}
+
+// cacheEntries lists every cache go-builder knows about.
+func cacheEntries(cfg *Config) []cacheEntry {
+	var out []cacheEntry
+	for _, kind := range []string{"toolchains", "tools"} { // one entry per item
+		dirs, _ := os.ReadDir(cacheDir(kind))
+		for _, d := range dirs {
+			p := filepath.Join(cacheDir(kind), d.Name())
+			out = append(out, cacheEntry{kind, p, dirSize(p)})
+		}
+	}
+	if p := proxyDir(cfg); dirSize(p) > 0 {
+		out = append(out, cacheEntry{"modproxy", p, dirSize(p)})
+	}
+	if p := filepath.Join(cfg.BuildDir, assetStampDir); dirSize(p) > 0 {
+		out = append(out, cacheEntry{"assets", p, dirSize(p)})
+	}
+	if rt, err := selectRuntime(dockerRuntimeName(cfg)); err == nil {
+		for _, v := range cacheVolumes(rt) {
+			out = append(out, cacheEntry{"volumes", v.Name, v.Size})
+		}
+	}
+	return out
+}
+
+// cacheCmd implements `go-builder cache ls|prune [kind…]`.
+func cacheCmd(cfg *Config, args []string, dry bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: go-builder cache ls | prune [toolchains|tools|modproxy|assets|volumes]…")
+	}
+	kinds := map[string]bool{}
+	for _, k := range args[1:] {
+		kinds[k] = true
+	}
+	entries := cacheEntries(cfg)
+
+	switch args[0] {
+	case "ls":
+		var total int64
+		for _, e := range entries {
+			fmt.Printf("%-10s %10s  %s\n", e.Kind, sizeOrUnknown(e.Size), e.Name)
+			if e.Size > 0 {
+				total += e.Size
+			}
+		}
+		fmt.Printf("%-10s %10s\n", "total", humanSize(total))
+		return nil
+	case "prune":
+		rtName := dockerRuntimeName(cfg)
+		for _, e := range entries {
+			if len(kinds) > 0 && !kinds[e.Kind] {
+				continue
+			}
+			if dry {
+				fmt.Printf("# Dry-run: remove %s %s\n", e.Kind, e.Name)
+				continue
+			}
+			var err error
+			if e.Kind == "volumes" {
+				rt, _ := selectRuntime(rtName)
+				err = exec.Command(rt.Bin(), "volume", "rm", e.Name).Run()
+			} else {
+				err = os.RemoveAll(e.Name)
+			}
+			if err != nil {
+				return fmt.Errorf("remove %s: %w", e.Name, err)
+			}
+			fmt.Printf("removed %s %s (%s)\n", e.Kind, e.Name, sizeOrUnknown(e.Size))
+		}
+		return nil
+	}
+	return fmt.Errorf("cache: unknown action %q (want ls | prune)", args[0])
+}
+
+func dockerRuntimeName(cfg *Config) string {
+	if cfg.Docker != nil {
+		return cfg.Docker.Runtime
+	}
+	return ""
+}
+
+// cacheVolumes lists go-builder-* volumes with their size where the
+// runtime reports it (docker system df -v).
+func cacheVolumes(rt containerRuntime) []cacheEntry {
+	out, err := exec.Command(rt.Bin(), "volume", "ls", "-q", "--filter", "name="+volumePrefix).Output()
+	if err != nil {
+		return nil
+	}
+	sizes := map[string]int64{}
+	if df, err := exec.Command(rt.Bin(), "system", "df", "-v", "--format", "{{json .Volumes}}").Output(); err == nil {
+		var vols []struct{ Name, Size string }
+		if json.Unmarshal(df, &vols) == nil {
+			for _, v := range vols {
+				sizes[v.Name] = parseHumanSize(v.Size)
+			}
+		}
+	}
+	var vols []cacheEntry
+	for _, name := range strings.Fields(string(out)) {
+		size, ok := sizes[name]
+		if !ok {
+			size = -1
+		}
+		vols = append(vols, cacheEntry{"volumes", name, size})
+	}
+	return vols
+}
+
+func dirSize(dir string) int64 {
+	var n int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if fi, err := d.Info(); err == nil {
+				n += fi.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// parseHumanSize reads docker's "12.3MB" style sizes; -1 if unparsable.
+func parseHumanSize(s string) int64 {
+	units := []struct {
+		suffix string
+		mult   float64
+	}{{"GB", 1e9}, {"MB", 1e6}, {"kB", 1e3}, {"B", 1}}
+	for _, u := range units {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			var f float64
+			if _, err := fmt.Sscanf(v, "%g", &f); err == nil {
+				return int64(f * u.mult)
+			}
+		}
+	}
+	return -1
+}
+
+func sizeOrUnknown(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	return humanSize(n)
+}
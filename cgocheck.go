@@ -0,0 +1,73 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   CGO expectation: CGO_ENABLED=0 in the config means no cgo in the binary
+   ------------------------------------------------------------------ */
+
+// cgoEnvKeys are the variables shown when the expectation fails.
+var cgoEnvKeys = []string{"CGO_ENABLED", "CC", "CXX", "CGO_CFLAGS", "CGO_LDFLAGS", "GOFLAGS", "GOENV"}
+
+// expectsNoCgo reports whether the config asks for CGO_ENABLED=0: the
+// target's env if it sets the variable literally, else the global env,
+// else the merged result.
+func expectsNoCgo(j buildJob) bool {
+	for _, layer := range []EnvMap{j.Target.Env, j.Cfg.Env} {
+		if v, ok := layer["CGO_ENABLED"]; ok && v.FromFile == "" {
+			return v.Value == "0"
+		}
+	}
+	return j.Env["CGO_ENABLED"] == "0"
+}
+
+// assertNoCgo checks the build settings recorded in the binary (as shown
+// by `go version -m`) and, for ELF, that no shared library is needed.
+// On failure it lists the cgo-related variables of every env layer.
+func assertNoCgo(j buildJob, base map[string]string) error {
+	var why []string
+	if bi, err := buildinfo.ReadFile(j.Out); err == nil {
+		for _, s := range bi.Settings {
+			if s.Key == "CGO_ENABLED" && s.Value != "0" {
+				why = append(why, "build info records CGO_ENABLED="+s.Value)
+			}
+		}
+	}
+	if bin, err := readBinary(j.Out); err == nil && bin.Format == "elf" && len(bin.Libs) > 0 {
+		why = append(why, "binary is dynamically linked ("+strings.Join(bin.Libs, ", ")+")")
+	}
+	if len(why) == 0 {
+		return nil
+	}
+
+	layers := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"host", base},
+		{"env", j.Cfg.Env.literals()},
+		{"target", j.Target.Env.literals()},
+		{"effective", j.Env},
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: CGO_ENABLED=0 expected but cgo was used: %s\n", j.Out, strings.Join(why, "; "))
+	fmt.Fprintf(&b, "  %-12s", "")
+	for _, l := range layers {
+		fmt.Fprintf(&b, " %-14s", l.name)
+	}
+	for _, k := range cgoEnvKeys {
+		fmt.Fprintf(&b, "\n  %-12s", k)
+		for _, l := range layers {
+			v, ok := l.env[k]
+			if !ok {
+				v = "-"
+			}
+			fmt.Fprintf(&b, " %-14s", v)
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
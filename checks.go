@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Checks gate: go vet, staticcheck, golangci-lint before the build
+   ------------------------------------------------------------------ */
+
+// checkRun is one planned check.
+type checkRun struct {
+	Name   string
+	Argv   []string
+	Allow  bool // allow_failure
+	Listed bool // any output lists offending files and fails the check
+}
+
+func (c *ChecksSection) runs(buildDir string) ([]checkRun, error) {
+	var out []checkRun
+	switch c.Fmt {
+	case "", "false":
+	case "true", "gofmt", "goimports":
+		files, err := goSources(".", buildDir)
+		if err != nil {
+			return nil, err
+		}
+		tool := "gofmt"
+		if c.Fmt == "goimports" {
+			tool = "goimports"
+		}
+		if len(files) > 0 {
+			out = append(out, checkRun{Name: tool, Argv: append([]string{tool, "-l"}, files...), Listed: true})
+		}
+	default:
+		return nil, fmt.Errorf("checks.fmt: want true | gofmt | goimports, got %q", c.Fmt)
+	}
+	add := func(name string, ck *Check, argv ...string) {
+		if ck == nil || ck.off {
+			return
+		}
+		if len(ck.Args) > 0 {
+			argv = append(argv[:1:1], ck.Args...)
+		}
+		out = append(out, checkRun{Name: name, Argv: argv, Allow: ck.AllowFailure})
+	}
+	add("vet", c.Vet, goBin, "vet", "./...")
+	add("staticcheck", c.Staticcheck, "staticcheck", "./...")
+	add("golangci-lint", c.GolangciLint, "golangci-lint", "run", "./...")
+	return out, nil
+}
+
+// goSources lists the .go files under root, skipping hidden directories,
+// vendor, testdata, node_modules and the build directory.
+func goSources(root, buildDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() {
+			if p != root && (strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" ||
+				name == "node_modules" || filepath.Clean(p) == filepath.Clean(buildDir)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".go") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// runChecks runs every configured check, printing each one's output as a
+// block, then a summary. Only failures without allow_failure fail the gate.
+func runChecks(cfg *Config, env []string, dry bool) error {
+	runs, err := cfg.Checks.runs(cfg.BuildDir)
+	if err != nil {
+		return err
+	}
+	if dry {
+		for _, r := range runs {
+			argv := r.Argv
+			if r.Listed {
+				argv = append(argv[:2:2], "<"+fmt.Sprint(len(r.Argv)-2)+" files>")
+			}
+			fmt.Fprintf(textOut, "\n# Dry-run: check %s\n%s\n", r.Name, strings.Join(argv, " "))
+		}
+		return nil
+	}
+
+	var summary, failed []string
+	for _, r := range runs {
+		fmt.Fprintf(textOut, ">>> Check %s\n", r.Name)
+		cmd := exec.Command(r.Argv[0], r.Argv[1:]...)
+		cmd.Env = env
+		var buf bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &buf, &buf
+		err := cmd.Run()
+		textOut.Write(buf.Bytes())
+		if err == nil && r.Listed && buf.Len() > 0 {
+			err = fmt.Errorf("%d files need formatting", strings.Count(buf.String(), "\n"))
+		}
+		switch {
+		case err == nil:
+			summary = append(summary, "  ✔ "+r.Name)
+		case r.Allow:
+			summary = append(summary, fmt.Sprintf("  ⚠ %s: %v (allowed to fail)", r.Name, checkErr(err)))
+		default:
+			summary = append(summary, fmt.Sprintf("  ✘ %s: %v", r.Name, checkErr(err)))
+			failed = append(failed, r.Name)
+		}
+	}
+	fmt.Fprintln(textOut, "Checks:")
+	fmt.Fprintln(textOut, strings.Join(summary, "\n"))
+	if len(failed) > 0 {
+		return fmt.Errorf("checks failed: %s (use --skip-checks to build anyway)", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkErr reports a missing tool as such rather than as an exec error.
+func checkErr(err error) error {
+	if ee, ok := err.(*exec.Error); ok {
+		return fmt.Errorf("%s not found in PATH", ee.Name)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   checksums.txt for release tooling (sha256sum -c compatible)
+   ------------------------------------------------------------------ */
+
+var checksumAlgos = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// writeChecksums writes one checksum file per algorithm into the build
+// dir, covering every artifact in the manifest. Paths are relative to the
+// build dir so `sha256sum -c` works from there.
+func writeChecksums(cfg *Config, m *Manifest, dry bool) error {
+	var files []string
+	for _, a := range m.Artifacts {
+		p := filepath.FromSlash(a.Path)
+		if _, err := os.Stat(p); err == nil {
+			files = append(files, p)
+		}
+	}
+	sort.Strings(files)
+
+	for _, cf := range checksumFiles(cfg) {
+		algo, out := cf.Algo, cf.Path
+		newHash, ok := checksumAlgos[algo]
+		if !ok {
+			return fmt.Errorf("checksums: unknown algorithm %q (want sha256 | sha512)", algo)
+		}
+		if dry {
+			fmt.Fprintf(textOut, "# Dry-run: %s checksums of %d artifact(s) → %s\n", algo, len(files), out)
+			continue
+		}
+		var b strings.Builder
+		for _, f := range files {
+			sum, err := fileHash(f, newHash())
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(cfg.BuildDir, f)
+			if err != nil {
+				rel = f
+			}
+			fmt.Fprintf(&b, "%s  %s\n", sum, filepath.ToSlash(rel))
+		}
+		if err := os.WriteFile(out, []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(textOut, "wrote %s\n", out)
+	}
+	return nil
+}
+
+type checksumFile struct{ Algo, Path string }
+
+// checksumFiles lists the checksum file of each configured algorithm.
+func checksumFiles(cfg *Config) []checksumFile {
+	c := cfg.Checksums
+	algos := c.Algorithms
+	if len(algos) == 0 {
+		algos = []string{"sha256"}
+	}
+	name := firstNonEmpty(c.Name, "checksums.txt")
+	var files []checksumFile
+	for _, algo := range algos {
+		out := filepath.Join(cfg.BuildDir, name)
+		if len(algos) > 1 {
+			ext := filepath.Ext(name)
+			out = filepath.Join(cfg.BuildDir, strings.TrimSuffix(name, ext)+"."+algo+ext)
+		}
+		files = append(files, checksumFile{algo, out})
+	}
+	return files
+}
+
+func fileHash(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
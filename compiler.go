@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Compiler back-ends: map the build section onto each toolchain CLI
+   ------------------------------------------------------------------ */
+
+// buildCommand returns the executable and arguments that build target t.
+func buildCommand(cfg *Config, t Target, out string) (string, []string, error) {
+	c := *cfg
+	b := cfg.Build
+	if b.Reproducible {
+		b.TrimPath = true
+		b.BuildVCS = firstNonEmpty(b.BuildVCS, "false")
+	}
+	c.Build = t.build(b)
+	cfg = &c
+	switch cfg.Build.BuildVCS {
+	case "", "true", "false", "auto":
+	default:
+		return "", nil, fmt.Errorf("build.buildvcs: want true | false | auto, got %q", cfg.Build.BuildVCS)
+	}
+	switch cfg.Build.LinkMode {
+	case "", "internal", "external", "auto":
+	default:
+		return "", nil, fmt.Errorf("build.linkmode: want internal | external | auto, got %q", cfg.Build.LinkMode)
+	}
+	switch cfg.Build.BuildMode {
+	case "", "exe", "pie", "c-shared", "c-archive", "plugin":
+	default:
+		return "", nil, fmt.Errorf("build.buildmode: want exe | pie | c-shared | c-archive | plugin, got %q", cfg.Build.BuildMode)
+	}
+	switch p := cfg.Build.PGO; p {
+	case "", "auto", "off":
+	default:
+		if _, err := os.Stat(p); err != nil {
+			return "", nil, fmt.Errorf("pgo profile: %w", err)
+		}
+	}
+	if t.GUI && t.OS != "windows" {
+		return "", nil, fmt.Errorf("%s: gui is only supported on windows targets", t.label(cfg))
+	}
+	if t.GUI && !hasSyso(cfg.Source, t) {
+		log.Printf("go-builder: warning: %s: gui: no .syso in %s, the binary has no application manifest (generate one with go-winres or rsrc)", t.label(cfg), cfg.Source)
+	}
+	switch c := t.compiler(cfg.Build.Compiler); c {
+	case "", "go":
+		if cfg.Build.Obfuscate {
+			return "garble", append(garbleFlags(cfg.Build.Garble), goArgs(cfg, t, "gc", out)...), nil
+		}
+		return goBin, goArgs(cfg, t, "gc", out), nil
+	case "gccgo":
+		if cfg.Build.Obfuscate {
+			return "", nil, fmt.Errorf("obfuscate is only supported with the go compiler")
+		}
+		if t.GUI {
+			return "", nil, fmt.Errorf("%s: gui is only supported with the go compiler", t.label(cfg))
+		}
+		return goBin, goArgs(cfg, t, "gccgo", out), nil
+	case "tinygo":
+		if cfg.Build.Obfuscate {
+			return "", nil, fmt.Errorf("obfuscate is only supported with the go compiler")
+		}
+		if t.GUI {
+			return "", nil, fmt.Errorf("%s: gui is only supported with the go compiler", t.label(cfg))
+		}
+		if cfg.Build.BuildMode != "" && cfg.Build.BuildMode != "exe" {
+			return "", nil, fmt.Errorf("%s: buildmode %s is not supported with tinygo", t.label(cfg), cfg.Build.BuildMode)
+		}
+		return "tinygo", tinygoArgs(cfg, t, out), nil
+	default:
+		return "", nil, fmt.Errorf("unknown compiler %q (want go | gccgo | tinygo)", c)
+	}
+}
+
+// goArgs builds `go build` arguments for the gc or gccgo toolchain.
+func goArgs(cfg *Config, t Target, compiler, out string) []string {
+	args := []string{"build"}
+	if compiler == "gccgo" {
+		args = append(args, "-compiler", "gccgo")
+	}
+	if cfg.Build.Verbose {
+		args = append(args, "-v")
+	}
+	if len(cfg.Build.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(cfg.Build.Tags, ","))
+	}
+	if cfg.Build.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	switch {
+	case compiler == "gccgo":
+		if f := gccgoFlags(cfg.Build.GcFlags); f != "" {
+			args = append(args, "-gccgoflags", f)
+		}
+	case cfg.Build.GcFlags != "":
+		args = append(args, "-gcflags", cfg.Build.GcFlags)
+	}
+	if cfg.Build.AsmFlags != "" && compiler != "gccgo" {
+		args = append(args, "-asmflags", cfg.Build.AsmFlags)
+	}
+	if cfg.Build.Mod != "" {
+		args = append(args, "-mod", cfg.Build.Mod)
+	}
+	if cfg.Build.BuildVCS != "" {
+		args = append(args, "-buildvcs="+cfg.Build.BuildVCS)
+	}
+	if cfg.Build.BuildMode != "" {
+		args = append(args, "-buildmode="+cfg.Build.BuildMode)
+	}
+	if cfg.Build.PGO != "" {
+		if compiler == "gccgo" {
+			log.Printf("go-builder: warning: -pgo is not supported by gccgo, ignored")
+		} else {
+			args = append(args, "-pgo="+cfg.Build.PGO)
+		}
+	}
+	if cfg.Build.Cover {
+		if compiler == "gccgo" {
+			log.Printf("go-builder: warning: -cover is not supported by gccgo, ignored")
+		} else {
+			args = append(args, "-cover")
+			if len(cfg.Build.CoverPkg) > 0 {
+				args = append(args, "-coverpkg", strings.Join(cfg.Build.CoverPkg, ","))
+			}
+		}
+	}
+	if cfg.Build.Race {
+		if compiler == "gccgo" {
+			log.Printf("go-builder: warning: -race is not supported by gccgo, ignored")
+		} else {
+			args = append(args, "-race")
+		}
+	}
+	b := cfg.Build
+	b.LdFlags = append(StringList{}, b.LdFlags...)
+	if b.Strip {
+		for _, f := range []string{"-s", "-w"} {
+			if !hasLdflag(b.LdFlags, f) {
+				b.LdFlags = append(b.LdFlags, f)
+			}
+		}
+	}
+	if t.GUI && !hasLdflag(b.LdFlags, "-H") {
+		b.LdFlags = append(b.LdFlags, "-H windowsgui")
+	}
+	if lf := composeLdflags(b); lf != "" {
+		args = append(args, "-ldflags", lf)
+	}
+	if out != "" {
+		args = append(args, "-o", out)
+	}
+	return append(args, cfg.Source)
+}
+
+// hasSyso reports whether the package in dir links a .syso (resources
+// such as the application manifest) into target t. A source that is
+// not a directory can't be checked and passes.
+func hasSyso(dir string, t Target) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return true
+	}
+	ctx := build.Default
+	ctx.GOOS, ctx.GOARCH = t.OS, t.Arch
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".syso" {
+			continue
+		}
+		if ok, err := ctx.MatchFile(dir, e.Name()); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// libraryMode reports whether buildmode produces a library rather than an
+// executable; such outputs are never checked for static linking.
+func libraryMode(mode string) bool {
+	return mode == "c-shared" || mode == "c-archive" || mode == "plugin"
+}
+
+// hasLdflag reports whether flag already appears among the plain ldflags.
+func hasLdflag(ldflags []string, flag string) bool {
+	for _, l := range ldflags {
+		for _, f := range strings.Fields(l) {
+			if f == flag || strings.HasPrefix(f, flag+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gccgoFlags translates gc compiler flags into their gccgo equivalents.
+// A leading package pattern ("all=") is dropped since -gccgoflags applies
+// to every package; flags without a counterpart are warned about.
+func gccgoFlags(gcflags string) string {
+	if i := strings.IndexByte(gcflags, '='); i >= 0 && !strings.HasPrefix(gcflags, "-") {
+		gcflags = gcflags[i+1:]
+	}
+	var out []string
+	for _, f := range strings.Fields(gcflags) {
+		switch f {
+		case "-N":
+			out = append(out, "-O0")
+		case "-l":
+			out = append(out, "-fno-inline")
+		case "-dwarf=false":
+			out = append(out, "-g0")
+		default:
+			// -m is gc's escape analysis report, but gcc's machine options
+			if strings.HasPrefix(f, "-f") || strings.HasPrefix(f, "-O") {
+				out = append(out, f) // already a gcc flag
+				continue
+			}
+			log.Printf("go-builder: warning: gcflag %s has no gccgo equivalent, ignored", f)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// garbleFlags are the garble options placed before its `build` verb;
+// the go build flags (including -ldflags -X vars) follow unchanged.
+func garbleFlags(g GarbleSection) []string {
+	var args []string
+	if g.Seed != "" {
+		args = append(args, "-seed="+g.Seed)
+	}
+	if g.Literals {
+		args = append(args, "-literals")
+	}
+	if g.Tiny {
+		args = append(args, "-tiny")
+	}
+	if g.DebugDir != "" {
+		args = append(args, "-debugdir="+g.DebugDir)
+	}
+	return args
+}
+
+// tinygoArgs maps the build section onto `tinygo build`.
+// gcflags, asmflags, race and trimpath have no tinygo equivalent and are
+// ignored; plain ldflags are dropped too, only -X vars are forwarded.
+func tinygoArgs(cfg *Config, t Target, out string) []string {
+	tg := t.tinygo(cfg.Build.TinyGo)
+	args := []string{"build"}
+	if tg.Target != "" {
+		args = append(args, "-target", tg.Target)
+	}
+	if tg.Opt != "" {
+		args = append(args, "-opt", tg.Opt)
+	}
+	if tg.GC != "" {
+		args = append(args, "-gc", tg.GC)
+	}
+	if tg.Scheduler != "" {
+		args = append(args, "-scheduler", tg.Scheduler)
+	}
+	if tg.Panic != "" {
+		args = append(args, "-panic", tg.Panic)
+	}
+	if tg.NoDebug || cfg.Build.Strip {
+		args = append(args, "-no-debug")
+	}
+	if cfg.Build.Verbose {
+		args = append(args, "-x")
+	}
+	if len(cfg.Build.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(cfg.Build.Tags, " "))
+	}
+	if lf := strings.Join(varFlags(cfg.Build.Vars), " "); lf != "" {
+		args = append(args, "-ldflags", lf)
+	}
+	if out != "" {
+		args = append(args, "-o", out)
+	}
+	return append(args, cfg.Source)
+}
+
+// defaultOutput is build_dir/<os>/<arch>/<name>; tinygo boards without
+// GOOS/GOARCH land in build_dir/<tinygo-target>/<name> instead.
+func defaultOutput(cfg *Config, t Target, name string) string {
+	var out string
+	tg := t.tinygo(cfg.Build.TinyGo)
+	if t.compiler(cfg.Build.Compiler) == "tinygo" && t.OS == "" && tg.Target != "" {
+		out = filepath.Join(cfg.BuildDir, tg.Target, name)
+	} else {
+		out = filepath.Join(cfg.BuildDir, t.OS, t.Arch, t.variantDir(), name)
+	}
+	if ext := outputExt(cfg, t); !strings.HasSuffix(out, ext) {
+		out += ext
+	}
+	return out
+}
+
+// outputExt is the file extension of t's binary: the tinygo format, the
+// library suffix for c-archive/c-shared/plugin builds, .exe on windows,
+// .wasm for WebAssembly, otherwise none.
+func outputExt(cfg *Config, t Target) string {
+	switch tg := t.tinygo(cfg.Build.TinyGo); {
+	case t.compiler(cfg.Build.Compiler) == "tinygo" && tg.Format != "":
+		return "." + tg.Format
+	case cfg.Build.BuildMode == "c-archive":
+		return ".a"
+	case cfg.Build.BuildMode == "c-shared" && t.OS == "windows":
+		return ".dll"
+	case cfg.Build.BuildMode == "c-shared" && (t.OS == "darwin" || t.OS == "ios"):
+		return ".dylib"
+	case cfg.Build.BuildMode == "c-shared" || cfg.Build.BuildMode == "plugin":
+		return ".so"
+	case t.OS == "windows":
+		return ".exe"
+	case t.Arch == "wasm":
+		return ".wasm"
+	}
+	return ""
+}
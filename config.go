@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -17,6 +18,7 @@ import (
 type StringList []string
 
 func (s *StringList) UnmarshalYAML(n *yaml.Node) error {
+	*s = nil // replace, never append to, a value decoded earlier
 	switch n.Kind {
 	case yaml.ScalarNode:
 		*s = []string{n.Value}
@@ -30,48 +32,711 @@ func (s *StringList) UnmarshalYAML(n *yaml.Node) error {
 	return nil
 }
 
+// EnvValue is a literal or {from_file: path}. Files are read when the
+// build runs (inside the container for docker builds; docker.env on the
+// host), so secrets stay out of the YAML and out of `docker run -e`
+// process listings.
+type EnvValue struct {
+	Value    string
+	FromFile string `yaml:"from_file"`
+}
+
+func (v *EnvValue) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		v.Value = n.Value
+		return nil
+	}
+	var ref struct {
+		FromFile string `yaml:"from_file"`
+	}
+	if err := n.Decode(&ref); err != nil || ref.FromFile == "" {
+		return errors.New("env: expected a value or {from_file: path}")
+	}
+	v.FromFile = ref.FromFile
+	return nil
+}
+
+// EnvMap is an env layer (global, per-target or docker).
+type EnvMap map[string]EnvValue
+
+// resolve returns the layer as plain values, reading from_file entries.
+// One trailing newline is trimmed, as secret files usually carry one.
+// In dry-run files are not read and a placeholder is shown instead.
+func (m EnvMap) resolve(dry bool) (map[string]string, error) {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		switch {
+		case v.FromFile == "":
+			out[k] = v.Value
+			continue
+		case dry:
+			out[k] = "<from_file " + v.FromFile + ">"
+			continue
+		}
+		b, err := os.ReadFile(v.FromFile)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", k, err)
+		}
+		out[k] = strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	}
+	return out, nil
+}
+
+// literals returns only the literal values; from_file entries are left
+// for the go-builder process inside the container to read.
+func (m EnvMap) literals() map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if v.FromFile == "" {
+			out[k] = v.Value
+		}
+	}
+	return out
+}
+
+// OutputSpec is the binary base name, or a mapping that also sets the
+// permissions applied to every produced file.
+type OutputSpec struct {
+	Name  string `yaml:"name"`
+	Mode  string `yaml:"mode"`  // octal, e.g. "0755"
+	Owner string `yaml:"owner"` // uid:gid or user:group
+	Umask string `yaml:"umask"` // octal, applied for the whole run (unix)
+}
+
+func (o *OutputSpec) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		o.Name = n.Value
+		return nil
+	}
+	type plain OutputSpec
+	return n.Decode((*plain)(o))
+}
+
 // Target = one GOOS / GOARCH build.
 type Target struct {
-	OS           string            `yaml:"os"`
-	Arch         string            `yaml:"arch"`
-	Output       string            `yaml:"output"`
-	Env          map[string]string `yaml:"env,omitempty"`
-	VerifyStatic *bool             `yaml:"verify_static,omitempty"` // override per-target
+	OS           string         `yaml:"os"`
+	Arch         string         `yaml:"arch"`
+	Output       string         `yaml:"output"`
+	Env          EnvMap         `yaml:"env,omitempty"`
+	VerifyStatic *bool          `yaml:"verify_static,omitempty"` // override per-target
+	Compiler     string         `yaml:"compiler,omitempty"`      // override per-target
+	TinyGo       *TinyGoSection `yaml:"tinygo,omitempty"`        // override per-target
+	GUI          bool           `yaml:"gui,omitempty"`           // windows: -H windowsgui, no console
+	Variant      string         `yaml:"variant,omitempty"`       // GOARM, GOAMD64, … for the arch
+	WasmExec     bool           `yaml:"wasm_exec,omitempty"`     // js/wasm: copy wasm_exec.js next to the binary
+
+	// Build overrides: lists and gcflags replace the global value, vars
+	// are merged key by key.
+	Tags     StringList        `yaml:"tags,omitempty"`
+	LdFlags  StringList        `yaml:"ldflags,omitempty"`
+	Vars     map[string]string `yaml:"vars,omitempty"`
+	GcFlags  string            `yaml:"gcflags,omitempty"`
+	TrimPath *bool             `yaml:"trimpath,omitempty"`
+	Timeout  string            `yaml:"timeout,omitempty"`
+	Retries  *int              `yaml:"retries,omitempty"`
+	PGO      string            `yaml:"pgo,omitempty"`
+
+	VerifyDynamicLibs StringList `yaml:"verify_dynamic_libs,omitempty"`
+	MaxGlibc          string     `yaml:"max_glibc,omitempty"`
+	VerifyStripped    *bool      `yaml:"verify_stripped,omitempty"`
+	SmokeTest         string     `yaml:"smoke_test,omitempty"` // true | false | command template
+
+	Docker *TargetDocker `yaml:"docker,omitempty"` // own builder container for this target
+	MacOS  *MacPackage   `yaml:"macos,omitempty"`  // darwin: overrides packages.macos
+}
+
+// TargetDocker overrides the docker section for one target; targets with
+// the same settings share a container.
+type TargetDocker struct {
+	Image    string   `yaml:"image"`
+	Platform string   `yaml:"platform"`
+	Setup    []string `yaml:"setup"` // replaces docker.setup
+}
+
+// TargetList is the targets section. An entry may list several os and/or
+// arch values; it expands into their cross product minus the combinations
+// in its `exclude` list (an empty field there matches any value).
+type TargetList []Target
+
+func (l *TargetList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.SequenceNode {
+		return errors.New("targets: expected a sequence")
+	}
+	for _, entry := range n.Content {
+		if entry.Kind != yaml.MappingNode {
+			return fmt.Errorf("targets: line %d: expected a mapping", entry.Line)
+		}
+		var matrix struct {
+			OS      StringList `yaml:"os"`
+			Arch    StringList `yaml:"arch"`
+			Variant StringList `yaml:"variant"`
+			Exclude []struct {
+				OS   string `yaml:"os"`
+				Arch string `yaml:"arch"`
+			} `yaml:"exclude"`
+		}
+		if err := entry.Decode(&matrix); err != nil {
+			return err
+		}
+		// decode the remaining keys once, as a plain target
+		rest := &yaml.Node{Kind: yaml.MappingNode}
+		for i := 0; i+1 < len(entry.Content); i += 2 {
+			switch entry.Content[i].Value {
+			case "os", "arch", "variant", "exclude":
+			default:
+				rest.Content = append(rest.Content, entry.Content[i], entry.Content[i+1])
+			}
+		}
+		var base Target
+		if err := rest.Decode(&base); err != nil {
+			return err
+		}
+		oses, arches, variants := []string(matrix.OS), []string(matrix.Arch), []string(matrix.Variant)
+		if len(oses) == 0 {
+			oses = []string{""}
+		}
+		if len(arches) == 0 {
+			arches = []string{""}
+		}
+		if len(variants) == 0 {
+			variants = []string{""}
+		}
+		if base.Output != "" && len(oses)*len(arches)*len(variants) > 1 {
+			return fmt.Errorf("targets: line %d: output cannot be set on a matrix entry", entry.Line)
+		}
+	combos:
+		for _, goos := range oses {
+			for _, goarch := range arches {
+				for _, x := range matrix.Exclude {
+					if (x.OS == "" || x.OS == goos) && (x.Arch == "" || x.Arch == goarch) {
+						continue combos
+					}
+				}
+				for _, v := range variants {
+					t := base
+					t.OS, t.Arch, t.Variant = goos, goarch, v
+					*l = append(*l, t)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// TinyGoSection holds options only understood by the tinygo compiler.
+type TinyGoSection struct {
+	Target    string `yaml:"target"`    // -target: board or wasm/wasip1
+	Opt       string `yaml:"opt"`       // -opt: 0 | 1 | 2 | s | z
+	GC        string `yaml:"gc"`        // -gc
+	Scheduler string `yaml:"scheduler"` // -scheduler
+	Panic     string `yaml:"panic"`     // -panic
+	NoDebug   bool   `yaml:"no_debug"`  // -no-debug
+	Format    string `yaml:"format"`    // output extension, e.g. uf2 | hex | bin
 }
 
 // DockerSection controls containerised builds.
 type DockerSection struct {
-	Image   string            `yaml:"image"`
-	WorkDir string            `yaml:"workdir"`
-	Shell   string            `yaml:"shell"`
-	Setup   []string          `yaml:"setup"`
-	Env     map[string]string `yaml:"env"`
+	Image      string     `yaml:"image"`
+	WorkDir    string     `yaml:"workdir"`
+	Shell      string     `yaml:"shell"`
+	Setup      []string   `yaml:"setup"`
+	Env        EnvMap     `yaml:"env"`
+	SELinux    string     `yaml:"selinux"`         // auto (default) | z | Z | none
+	UserNS     string     `yaml:"userns"`          // auto (default) | none | keep-id | host | ...
+	User       string     `yaml:"user"`            // host (default): run as your uid:gid | image | uid[:gid]
+	Fallback   string     `yaml:"fallback"`        // fail (default) | warn | local
+	Runtime    string     `yaml:"runtime"`         // auto (default) | docker | podman | nerdctl
+	Namespace  string     `yaml:"namespace"`       // nerdctl containerd namespace (k8s.io for Rancher Desktop)
+	Context    string     `yaml:"context"`         // docker context / podman connection to run on (may be remote)
+	CopyBack   StringList `yaml:"copy_back"`       // remote engines: globs under the workdir copied back besides build_dir
+	Pull       string     `yaml:"pull"`            // always | missing | never (runtime default if empty)
+	PullPolicy string     `yaml:"pull_policy"`     // alias of pull: always | if-not-present | never
+	Platform   string     `yaml:"platform"`        // run the builder as e.g. linux/arm64 (emulated when foreign)
+	SetupQemu  bool       `yaml:"setup_qemu"`      // register QEMU binfmt handlers before the build
+	Network    string     `yaml:"network"`         // --network: none (prove the build needs no network) | host | <name>
+	SSHAgent   bool       `yaml:"ssh_agent"`       // forward $SSH_AUTH_SOCK for private modules over SSH
+	CACerts    StringList `yaml:"ca_certs"`        // extra PEM CA certificates to trust in the container
+	Proxy      *bool      `yaml:"proxy,omitempty"` // forward the host's HTTP(S)_PROXY/NO_PROXY/GOPROXY (default true)
+	// VerifyDigest resolves the image to a digest, checks it against an
+	// image@sha256:… pin and records it in the manifest.
+	VerifyDigest bool `yaml:"verify_digest"`
+	// Secrets are mounted read-only at /run/secrets/<id>.
+	Secrets []Secret `yaml:"secrets"`
+	// Entrypoint overrides the image entrypoint; "" clears it so the
+	// `<shell> -c …` command runs directly.
+	Entrypoint *string        `yaml:"entrypoint"`
+	ExtraArgs  []string       `yaml:"extra_args"` // appended to `run`, e.g. --ulimit nofile=65536
+	Init       bool           `yaml:"init"`       // --init: reap zombies, forward signals
+	Timeout    string         `yaml:"timeout"`    // whole container run, e.g. 30m
+	Retries    int            `yaml:"retries"`    // re-run the container on failure
+	Security   DockerSecurity `yaml:"security"`
+	Auth       *DockerAuth    `yaml:"auth,omitempty"`
+	// Dockerfile builds the builder image before the run; its directory
+	// is the build context.
+	Dockerfile string            `yaml:"dockerfile"`
+	BuildArgs  map[string]string `yaml:"build_args"` // --build-arg for dockerfile
+
+	pullBase bool // dockerfile with pull: always → build --pull
+	remote   bool // engine on another machine: sources are copied, not bind-mounted
+	// CacheModules keeps the module cache across runs: true for the
+	// go-builder-modcache volume, or a host directory.
+	CacheModules string `yaml:"cache_modules"`
+	CacheBuild   string `yaml:"cache_build"` // same for GOCACHE: true (go-builder-gocache) | host dir
+}
+
+// DockerAuth logs in to a private registry before the image is pulled.
+type DockerAuth struct {
+	Registry string `yaml:"registry"` // default: the registry in docker.image
+	Username string `yaml:"username"`
+	Password string `yaml:"password"` // use ${VAR}; passed on stdin
+	Config   string `yaml:"config"`   // reuse this docker config dir instead (DOCKER_CONFIG)
+}
+
+// DockerSecurity hardens the build container.
+type DockerSecurity struct {
+	CapDrop         []string `yaml:"cap_drop"`          // --cap-drop, e.g. [ALL]
+	CapAdd          []string `yaml:"cap_add"`           // --cap-add
+	Seccomp         string   `yaml:"seccomp"`           // profile path or "unconfined"
+	NoNewPrivileges bool     `yaml:"no_new_privileges"` // --security-opt no-new-privileges
+	ReadOnly        bool     `yaml:"read_only"`         // --read-only root filesystem
+	Tmpfs           []string `yaml:"tmpfs"`             // --tmpfs; default /tmp:exec with read_only
+}
+
+// Secret is a token exposed to the container as a file, never as env.
+type Secret struct {
+	ID   string `yaml:"id"`
+	File string `yaml:"file"` // host file holding the value
+	Env  string `yaml:"env"`  // or host env var holding the value
+}
+
+// AssetStep is a pre-build pipeline (npm, protoc, …) whose outputs are
+// usually go:embed'ed. It is skipped while its inputs are unchanged.
+type AssetStep struct {
+	Name    string     `yaml:"name"`
+	Dir     string     `yaml:"dir"`     // working directory (default: project root)
+	Run     StringList `yaml:"run"`     // shell commands, run in order
+	Inputs  []string   `yaml:"inputs"`  // globs; ** spans directories
+	Outputs []string   `yaml:"outputs"` // globs that must exist afterwards
+}
+
+// TestSection runs `go test` before building; a failure aborts the run.
+type TestSection struct {
+	Packages []string `yaml:"packages"` // default ./...
+	Flags    []string `yaml:"flags"`    // extra go test flags, e.g. [-count=1, -short]
+	Timeout  string   `yaml:"timeout"`  // -timeout
+	Race     bool     `yaml:"race"`     // -race
+}
+
+// BenchSection gates the build on benchmark regressions against the
+// previous run kept in the history directory.
+type BenchSection struct {
+	Packages  []string `yaml:"packages"`  // default ./...
+	Run       string   `yaml:"run"`       // -bench regexp (default .)
+	Count     int      `yaml:"count"`     // -count; 6+ gives meaningful p-values
+	BenchTime string   `yaml:"benchtime"` // -benchtime
+	Threshold float64  `yaml:"threshold"` // max slowdown in percent (default 5)
+	Mode      string   `yaml:"mode"`      // fail (default) | warn
+	History   string   `yaml:"history"`   // default build_dir/.bench
+}
+
+// ChecksSection runs linters before the build.
+type ChecksSection struct {
+	Vet          *Check `yaml:"vet"`           // go vet ./...
+	Staticcheck  *Check `yaml:"staticcheck"`   // staticcheck ./...
+	GolangciLint *Check `yaml:"golangci_lint"` // golangci-lint run ./...
+	Fmt          string `yaml:"fmt"`           // true | gofmt | goimports: list unformatted files
+}
+
+// Check is `true`, or args replacing the default ones plus allow_failure.
+type Check struct {
+	Args         []string `yaml:"args"`
+	AllowFailure bool     `yaml:"allow_failure"` // report, but don't fail the build
+	off          bool
+}
+
+func (c *Check) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var on bool
+		if err := n.Decode(&on); err != nil {
+			return fmt.Errorf("line %d: want true, false or a mapping", n.Line)
+		}
+		c.off = !on
+		return nil
+	}
+	type plain Check
+	return n.Decode((*plain)(c))
+}
+
+// ProvenanceSection writes SLSA provenance next to every artifact;
+// `provenance: true` enables it unsigned.
+type ProvenanceSection struct {
+	Key string `yaml:"key"` // PEM private key (ECDSA, Ed25519 or RSA) to sign the DSSE envelope
+}
+
+func (p *ProvenanceSection) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var on bool
+		if err := n.Decode(&on); err != nil || !on {
+			return fmt.Errorf("provenance: line %d: want true or a mapping", n.Line)
+		}
+		return nil
+	}
+	type plain ProvenanceSection
+	return n.Decode((*plain)(p))
+}
+
+// SignSection signs release files once the build is done.
+type SignSection struct {
+	Cosign *CosignSign `yaml:"cosign,omitempty"`
+	GPG    *GPGSign    `yaml:"gpg,omitempty"`
+	Rekor  *RekorLog   `yaml:"rekor,omitempty"`
+}
+
+// RekorLog uploads signatures and signed provenance to a Rekor
+// transparency log; `rekor: true` uses the public instance.
+type RekorLog struct {
+	URL string `yaml:"url"` // default https://rekor.sigstore.dev
+}
+
+func (r *RekorLog) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var on bool
+		if err := n.Decode(&on); err != nil || !on {
+			return fmt.Errorf("sign.rekor: line %d: want true or a mapping", n.Line)
+		}
+		return nil
+	}
+	type plain RekorLog
+	return n.Decode((*plain)(r))
+}
+
+// CosignSign signs with `cosign sign-blob`; `cosign: true` signs keyless.
+type CosignSign struct {
+	Key       string     `yaml:"key"`       // key file or KMS URI; empty: keyless (OIDC + Fulcio certificate)
+	Artifacts string     `yaml:"artifacts"` // all (default) | binaries | checksums
+	Args      StringList `yaml:"args"`      // extra sign-blob flags
+}
+
+func (c *CosignSign) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var on bool
+		if err := n.Decode(&on); err != nil || !on {
+			return fmt.Errorf("sign.cosign: line %d: want true or a mapping", n.Line)
+		}
+		return nil
+	}
+	type plain CosignSign
+	return n.Decode((*plain)(c))
+}
+
+// GPGSign writes armored detached signatures (<file>.asc); `gpg: true`
+// uses $GPG_KEY_ID or gpg's default key.
+type GPGSign struct {
+	Key       string     `yaml:"key"`       // key id or fingerprint (--local-user)
+	Artifacts string     `yaml:"artifacts"` // checksums (default) | binaries | all
+	Args      StringList `yaml:"args"`      // extra gpg flags, e.g. --homedir
+}
+
+func (g *GPGSign) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var on bool
+		if err := n.Decode(&on); err != nil || !on {
+			return fmt.Errorf("sign.gpg: line %d: want true or a mapping", n.Line)
+		}
+		return nil
+	}
+	type plain GPGSign
+	return n.Decode((*plain)(g))
+}
+
+// SizeDiffSection configures `go-builder size-diff`.
+type SizeDiffSection struct {
+	Threshold float64 `yaml:"threshold"` // max growth in percent per artifact; 0 = report only
+	History   string  `yaml:"history"`   // default build_dir/.sizes
+}
+
+// ModulesSection verifies go.sum before the build.
+type ModulesSection struct {
+	Verify  bool `yaml:"verify"`  // go mod download -x, then go mod verify
+	Retries int  `yaml:"retries"` // extra download attempts on network errors
+}
+
+// LicensesSection is the license policy for linked modules, by SPDX id.
+type LicensesSection struct {
+	Allow   []string `yaml:"allow"`   // when set, every module must use one of these
+	Deny    []string `yaml:"deny"`    // always rejected
+	Notices bool     `yaml:"notices"` // write THIRD_PARTY_NOTICES into build_dir
+}
+
+// ChecksumsSection writes checksum files for every artifact.
+type ChecksumsSection struct {
+	Name       string   `yaml:"name"`       // default checksums.txt; checksums.<algo>.txt for several
+	Algorithms []string `yaml:"algorithms"` // sha256 (default) | sha512
+}
+
+// PackagesSection builds distribution packages from the built binaries.
+// The metadata at the top is shared by every format.
+type PackagesSection struct {
+	Name        string `yaml:"name"`        // default: the binary name
+	Version     string `yaml:"version"`     // default: version, else the latest git tag (without v)
+	Maintainer  string `yaml:"maintainer"`  // "Jane Doe <jane@example.com>"
+	Description string `yaml:"description"` // one line
+	Homepage    string `yaml:"homepage"`
+	License     string `yaml:"license"` // SPDX id
+
+	// Linux packages, built with nfpm for every linux target.
+	Formats     StringList        `yaml:"formats"` // deb | rpm | apk
+	BinDir      string            `yaml:"bindir"`  // default /usr/bin
+	Depends     StringList        `yaml:"depends"`
+	Systemd     StringList        `yaml:"systemd"`     // unit files, installed in /usr/lib/systemd/system
+	Configs     map[string]string `yaml:"configs"`     // file → install path, kept when changed locally
+	Completions Completions       `yaml:"completions"` // shell completion files
+	Scripts     PackageScripts    `yaml:"scripts"`     // maintainer scripts
+
+	Windows  *WindowsPackage  `yaml:"windows,omitempty"`  // installer for windows targets
+	MacOS    *MacPackage      `yaml:"macos,omitempty"`    // .pkg / .dmg for darwin targets
+	Snap     *SnapPackage     `yaml:"snap,omitempty"`     // .snap for linux targets
+	AppImage *AppImagePackage `yaml:"appimage,omitempty"` // .AppImage for linux targets
+	Flatpak  *FlatpakPackage  `yaml:"flatpak,omitempty"`  // Flatpak manifest (and bundle) for linux targets
+}
+
+// FlatpakPackage writes a flatpak-builder manifest for every linux
+// binary and optionally builds it into a .flatpak bundle.
+type FlatpakPackage struct {
+	AppID          string     `yaml:"app_id"`          // reverse DNS, required: com.example.MyApp
+	Name           string     `yaml:"name"`            // default packages.name
+	Runtime        string     `yaml:"runtime"`         // default org.freedesktop.Platform
+	RuntimeVersion string     `yaml:"runtime_version"` // default 23.08
+	SDK            string     `yaml:"sdk"`             // default org.freedesktop.Sdk
+	FinishArgs     StringList `yaml:"finish_args"`     // sandbox permissions; default wayland, x11, ipc, dri
+	Icon           string     `yaml:"icon"`            // 256x256 .png or .svg
+	Desktop        string     `yaml:"desktop"`         // own .desktop file instead of the generated one
+	Metainfo       string     `yaml:"metainfo"`        // own AppStream file instead of the generated one
+	Categories     StringList `yaml:"categories"`      // desktop categories, default Utility
+	Bundle         bool       `yaml:"bundle"`          // also build a .flatpak with flatpak-builder
+}
+
+// AppImagePackage builds a portable AppImage of every linux binary.
+type AppImagePackage struct {
+	Name       string     `yaml:"name"`       // application name in the desktop file, default packages.name
+	Icon       string     `yaml:"icon"`       // .png or .svg, required
+	Desktop    string     `yaml:"desktop"`    // own .desktop file instead of the generated one
+	Categories StringList `yaml:"categories"` // desktop categories, default Utility
+	GUI        bool       `yaml:"gui"`        // not a terminal application
+}
+
+// SnapPackage builds a snap of every linux binary with snapcraft.
+type SnapPackage struct {
+	Name        string     `yaml:"name"`        // default packages.name
+	Summary     string     `yaml:"summary"`     // default packages.description
+	Description string     `yaml:"description"` // default packages.description
+	Base        string     `yaml:"base"`        // core22 (default) | core24 | …
+	Grade       string     `yaml:"grade"`       // stable (default) | devel
+	Confinement string     `yaml:"confinement"` // strict (default) | classic | devmode
+	Plugs       StringList `yaml:"plugs"`       // interfaces of the app: network, home, …
+}
+
+// MacPackage wraps darwin binaries into installer packages and disk
+// images. targets[*].macos overrides it field by field.
+type MacPackage struct {
+	Formats         StringList `yaml:"formats"`          // pkg | dmg
+	Identifier      string     `yaml:"identifier"`       // com.example.myapp, required for pkg
+	InstallLocation string     `yaml:"install_location"` // pkg: default /usr/local/bin
+	VolumeName      string     `yaml:"volume_name"`      // dmg: default the package name
+	SignIdentity    string     `yaml:"sign_identity"`    // pkg: "Developer ID Installer: …"
+}
+
+// WindowsPackage builds an NSIS installer for every windows target.
+type WindowsPackage struct {
+	Name        string `yaml:"name"`         // display name, default packages.name
+	Publisher   string `yaml:"publisher"`    // default packages.maintainer
+	InstallDir  string `yaml:"install_dir"`  // default $PROGRAMFILES64\<name>; NSIS variables allowed, only ${VAR} is expanded
+	Icon        string `yaml:"icon"`         // .ico for the installer, uninstaller and Apps & features
+	LicenseFile string `yaml:"license_file"` // shown on a license page
+	Shortcut    bool   `yaml:"shortcut"`     // start menu shortcut
+}
+
+// Completions are shell completion scripts shipped in packages.
+type Completions struct {
+	Bash string `yaml:"bash"`
+	Zsh  string `yaml:"zsh"`
+	Fish string `yaml:"fish"`
+}
+
+// PackageScripts run on the target system when a package is installed
+// or removed.
+type PackageScripts struct {
+	PostInstall string `yaml:"postinstall"`
+	PreRemove   string `yaml:"preremove"`
+	PostRemove  string `yaml:"postremove"`
+}
+
+// HomebrewSection generates a Homebrew formula from the manifest after
+// the build and optionally pushes it to a tap.
+type HomebrewSection struct {
+	Name          string     `yaml:"name"`        // formula name, default the binary name
+	Binary        string     `yaml:"binary"`      // with several binaries: the one to install
+	Description   string     `yaml:"description"` // desc
+	Homepage      string     `yaml:"homepage"`
+	License       string     `yaml:"license"`        // SPDX id
+	URL           string     `yaml:"url"`            // download URL template: {{.Tag}} {{.Version}} {{.File}} {{.OS}} {{.Arch}} {{.Name}}
+	Test          StringList `yaml:"test"`           // arguments the formula test runs the binary with, e.g. --version
+	Tap           string     `yaml:"tap"`            // git URL of the tap repository
+	Branch        string     `yaml:"branch"`         // default the tap's default branch
+	Directory     string     `yaml:"directory"`      // default Formula
+	CommitMessage string     `yaml:"commit_message"` // default "<name> <version>"
+}
+
+// ImagesSection builds a runtime container image per linux target from
+// the built binary.
+type ImagesSection struct {
+	Repository string            `yaml:"repository"` // registry/name, required: ghcr.io/me/app
+	Binary     string            `yaml:"binary"`     // with several binaries: the one to ship
+	Base       string            `yaml:"base"`       // default gcr.io/distroless/static-debian12
+	Tags       StringList        `yaml:"tags"`       // default the version; -<platform> is appended with several targets
+	Labels     map[string]string `yaml:"labels"`     // over the OCI labels go-builder sets
+	Entrypoint StringList        `yaml:"entrypoint"` // default the binary, in /usr/local/bin
+	Cmd        StringList        `yaml:"cmd"`
+	User       string            `yaml:"user"`  // e.g. nonroot:nonroot
+	Files      map[string]string `yaml:"files"` // extra file → path in the image
+	Push       bool              `yaml:"push"`
+	Manifest   *bool             `yaml:"manifest,omitempty"` // multi-arch tags over the pushed images, default on
+	Tarball    string            `yaml:"tarball"`            // docker | oci: assemble without an engine, write tarballs
+}
+
+// ProxySection configures the local module proxy cache.
+type ProxySection struct {
+	Dir  string `yaml:"dir"`  // default: <user cache>/go-builder/modproxy
+	Addr string `yaml:"addr"` // `proxy serve` listen address (default 127.0.0.1:3000)
+	Use  bool   `yaml:"use"`  // local and docker builds use it as GOPROXY
 }
 
 // Build-level flags.
 type BuildSection struct {
-	Tags         []string          `yaml:"tags"`
-	LdFlags      StringList        `yaml:"ldflags"`
-	Vars         map[string]string `yaml:"vars"`
-	GcFlags      string            `yaml:"gcflags"`
-	AsmFlags     string            `yaml:"asmflags"`
-	Mod          string            `yaml:"mod"`
-	Race         bool              `yaml:"race"`
-	TrimPath     bool              `yaml:"trimpath"`
-	Verbose      bool              `yaml:"verbose"`
-	Debug        bool              `yaml:"debug"`
-	VerifyStatic bool              `yaml:"verify_static"`
+	Tags           []string          `yaml:"tags"`
+	LdFlags        StringList        `yaml:"ldflags"`
+	LinkMode       string            `yaml:"linkmode"`   // -linkmode: internal | external | auto
+	ExtLdFlags     StringList        `yaml:"extldflags"` // -extldflags, quoted for you
+	Vars           map[string]string `yaml:"vars"`
+	GcFlags        string            `yaml:"gcflags"`
+	AsmFlags       string            `yaml:"asmflags"`
+	Mod            string            `yaml:"mod"`
+	BuildVCS       string            `yaml:"buildvcs"` // -buildvcs: true | false | auto
+	Race           bool              `yaml:"race"`
+	TrimPath       bool              `yaml:"trimpath"`
+	Reproducible   bool              `yaml:"reproducible"` // trimpath, buildvcs=false, SOURCE_DATE_EPOCH
+	Cover          bool              `yaml:"cover"`        // -cover: coverage-instrumented binaries
+	CoverPkg       StringList        `yaml:"coverpkg"`     // -coverpkg patterns
+	CoverDir       string            `yaml:"coverdir"`     // GOCOVERDIR to run them with, recorded in the manifest
+	Verbose        bool              `yaml:"verbose"`
+	Debug          bool              `yaml:"debug"`
+	VerifyStatic   bool              `yaml:"verify_static"`
+	VerifyArch     bool              `yaml:"verify_arch"`     // check the output's header matches GOOS/GOARCH
+	VerifyStripped bool              `yaml:"verify_stripped"` // fail if symbols or DWARF remain
+	// VerifyDynamicLibs lists the shared libraries a dynamic binary may need.
+	VerifyDynamicLibs StringList    `yaml:"verify_dynamic_libs"`
+	MaxGlibc          string        `yaml:"max_glibc"`  // newest GLIBC_x.y symbol version allowed, e.g. 2.17
+	SmokeTest         string        `yaml:"smoke_test"` // run after each build: true | command template
+	Compiler          string        `yaml:"compiler"`   // go (default) | gccgo | tinygo
+	TinyGo            TinyGoSection `yaml:"tinygo"`
+	Parallel          int           `yaml:"parallel"`      // targets built concurrently (--parallel)
+	Strip             bool          `yaml:"strip"`         // add -s -w to ldflags
+	StripObjcopy      bool          `yaml:"strip_objcopy"` // also objcopy --strip-all ELF outputs
+	PGO               string        `yaml:"pgo"`           // -pgo: profile path | auto | off
+	BuildMode         string        `yaml:"buildmode"`     // exe | pie | c-shared | c-archive | plugin
+	Timeout           string        `yaml:"timeout"`       // per compiler run, e.g. 10m
+	Retries           int           `yaml:"retries"`       // re-run a failed or timed-out compile
+	// ContinueOnError builds the remaining targets after a failure (--keep-going).
+	ContinueOnError bool          `yaml:"continue_on_error"`
+	Obfuscate       bool          `yaml:"obfuscate"` // build through garble
+	Garble          GarbleSection `yaml:"garble"`
+}
+
+// GarbleSection configures garble when build.obfuscate is set.
+type GarbleSection struct {
+	Seed     string `yaml:"seed"`     // -seed: base64 value or "random"
+	Literals bool   `yaml:"literals"` // -literals: obfuscate string literals
+	Tiny     bool   `yaml:"tiny"`     // -tiny: strip extra runtime info
+	DebugDir string `yaml:"debugdir"` // -debugdir: write obfuscated sources here
 }
 
 // Top-level config.
 type Config struct {
-	BuildDir string            `yaml:"build_dir"`
-	Source   string            `yaml:"source"`
-	Output   string            `yaml:"output"`
-	Env      map[string]string `yaml:"env"`
-	Build    BuildSection      `yaml:"build"`
-	Targets  []Target          `yaml:"targets"`
-	Docker   *DockerSection    `yaml:"docker,omitempty"`
+	BuildDir   string             `yaml:"build_dir"`
+	Source     string             `yaml:"source"`
+	GoVersion  string             `yaml:"go_version"` // e.g. 1.22.5: download and use that release
+	Version    string             `yaml:"version"`    // {{.Version}} in output templates (default dev)
+	Output     OutputSpec         `yaml:"output"`
+	Env        EnvMap             `yaml:"env"`
+	Build      BuildSection       `yaml:"build"`
+	Targets    TargetList         `yaml:"targets"`
+	Docker     *DockerSection     `yaml:"docker,omitempty"`
+	Assets     []AssetStep        `yaml:"assets"`
+	Test       *TestSection       `yaml:"test,omitempty"`
+	Checks     *ChecksSection     `yaml:"checks,omitempty"`
+	Licenses   *LicensesSection   `yaml:"licenses,omitempty"`
+	Modules    *ModulesSection    `yaml:"modules,omitempty"`
+	Bench      *BenchSection      `yaml:"bench,omitempty"`
+	Proxy      *ProxySection      `yaml:"proxy,omitempty"`
+	Binaries   []Binary           `yaml:"binaries,omitempty"`
+	Checksums  *ChecksumsSection  `yaml:"checksums,omitempty"`
+	DepsReport StringList         `yaml:"deps_report,omitempty"` // txt | json: build_dir/deps.<fmt>
+	SizeDiff   *SizeDiffSection   `yaml:"size_diff,omitempty"`
+	Provenance *ProvenanceSection `yaml:"provenance,omitempty"`
+	Sign       *SignSection       `yaml:"sign,omitempty"`
+	Packages   *PackagesSection   `yaml:"packages,omitempty"`
+	Homebrew   *HomebrewSection   `yaml:"homebrew,omitempty"`
+	Images     *ImagesSection     `yaml:"images,omitempty"`
+
+	binary string // set on the per-binary configs from binaries()
+}
+
+// Binary is one entry of the binaries list. Unset fields inherit the
+// top-level value; build is decoded over a copy of the top-level build
+// section, so it only needs the keys that differ.
+type Binary struct {
+	Name    string      `yaml:"name"` // default: base name of source
+	Source  string      `yaml:"source"`
+	Output  *OutputSpec `yaml:"output"`
+	Targets TargetList  `yaml:"targets"`
+	Build   yaml.Node   `yaml:"build"`
+}
+
+// binaries returns one config per binaries entry, or cfg itself when the
+// list is empty. Call it before expandEnv.
+func (cfg *Config) binaries() ([]*Config, error) {
+	if len(cfg.Binaries) == 0 {
+		return []*Config{cfg}, nil
+	}
+	out := make([]*Config, 0, len(cfg.Binaries))
+	for i, b := range cfg.Binaries {
+		if b.Source == "" {
+			return nil, fmt.Errorf("binaries[%d]: source is required", i)
+		}
+		c := *cfg
+		c.Binaries = nil
+		c.binary = firstNonEmpty(b.Name, filepath.Base(b.Source))
+		c.Source = b.Source
+		if b.Output != nil {
+			c.Output = *b.Output
+		} else {
+			c.Output.Name = b.Name
+		}
+		if len(b.Targets) > 0 {
+			c.Targets = b.Targets
+		}
+		if !b.Build.IsZero() {
+			c.Build.Vars = make(map[string]string, len(cfg.Build.Vars))
+			for k, v := range cfg.Build.Vars {
+				c.Build.Vars[k] = v
+			}
+			if err := b.Build.Decode(&c.Build); err != nil {
+				return nil, fmt.Errorf("binaries[%d].build: %w", i, err)
+			}
+		}
+		out = append(out, &c)
+	}
+	return out, nil
 }
 
 /* ──────────────── Load & expand ──────────────── */
@@ -97,16 +762,7 @@ func LoadConfig(path string) (*Config, error) {
 // expandEnv does ${VAR} / ${VAR:-def} replacement.
 func expandEnv(cfg *Config) *Config {
 	exp := func(s string) string {
-		return os.Expand(s, func(k string) string {
-			if i := strings.Index(k, ":-"); i >= 0 {
-				name, def := k[:i], k[i+2:]
-				if v, ok := os.LookupEnv(name); ok && v != "" {
-					return v
-				}
-				return def
-			}
-			return os.Getenv(k)
-		})
+		return os.Expand(s, lookupDefault)
 	}
 	dupMap := func(m map[string]string) map[string]string {
 		out := make(map[string]string, len(m))
@@ -115,54 +771,299 @@ func expandEnv(cfg *Config) *Config {
 		}
 		return out
 	}
+	dupList := func(in []string) []string {
+		o := make([]string, len(in))
+		for i, s := range in {
+			o[i] = exp(s)
+		}
+		return o
+	}
+	expMac := func(m *MacPackage) *MacPackage {
+		if m == nil {
+			return nil
+		}
+		return &MacPackage{Formats: dupList(m.Formats), Identifier: exp(m.Identifier), InstallLocation: exp(m.InstallLocation),
+			VolumeName: exp(m.VolumeName), SignIdentity: exp(m.SignIdentity)}
+	}
+	dupEnv := func(m EnvMap) EnvMap {
+		out := make(EnvMap, len(m))
+		for k, v := range m {
+			out[exp(k)] = EnvValue{Value: exp(v.Value), FromFile: exp(v.FromFile)}
+		}
+		return out
+	}
 	out := *cfg
 	out.BuildDir = exp(cfg.BuildDir)
 	out.Source = exp(cfg.Source)
-	out.Output = exp(cfg.Output)
-	out.Env = dupMap(cfg.Env)
+	out.Version = exp(cfg.Version)
+	out.GoVersion = exp(cfg.GoVersion)
+	out.Output.Name = exp(cfg.Output.Name)
+	out.Output.Owner = exp(cfg.Output.Owner)
+	out.Env = dupEnv(cfg.Env)
 
 	// build section
-	out.Build.LdFlags = func(in StringList) StringList {
-		o := make(StringList, len(in))
-		for i, s := range in {
-			o[i] = exp(s)
-		}
-		return o
-	}(cfg.Build.LdFlags)
+	out.Build.LdFlags = dupList(cfg.Build.LdFlags)
 	out.Build.Vars = dupMap(cfg.Build.Vars)
-	out.Build.Tags = func(in []string) []string {
-		o := make([]string, len(in))
-		for i, s := range in {
-			o[i] = exp(s)
-		}
-		return o
-	}(cfg.Build.Tags)
+	out.Build.LinkMode = exp(cfg.Build.LinkMode)
+	out.Build.ExtLdFlags = dupList(cfg.Build.ExtLdFlags)
+	out.Build.Tags = dupList(cfg.Build.Tags)
 	out.Build.GcFlags = exp(cfg.Build.GcFlags)
+	out.Build.Timeout = exp(cfg.Build.Timeout)
+	out.Build.PGO = exp(cfg.Build.PGO)
+	out.Build.CoverDir = exp(cfg.Build.CoverDir)
+	out.Build.BuildMode = exp(cfg.Build.BuildMode)
 	out.Build.AsmFlags = exp(cfg.Build.AsmFlags)
 	out.Build.Mod = exp(cfg.Build.Mod)
+	out.Build.BuildVCS = exp(cfg.Build.BuildVCS)
+	out.Build.Compiler = exp(cfg.Build.Compiler)
+	out.Build.TinyGo.Target = exp(cfg.Build.TinyGo.Target)
+	out.Build.Garble.Seed = exp(cfg.Build.Garble.Seed)
+	out.Build.Garble.DebugDir = exp(cfg.Build.Garble.DebugDir)
+
+	if cfg.Test != nil {
+		t := *cfg.Test
+		t.Packages = dupList(t.Packages)
+		t.Flags = dupList(t.Flags)
+		t.Timeout = exp(t.Timeout)
+		out.Test = &t
+	}
+	if cfg.Bench != nil {
+		b := *cfg.Bench
+		b.History = exp(b.History)
+		b.Mode = exp(b.Mode)
+		out.Bench = &b
+	}
+
+	if cfg.Checksums != nil {
+		c := *cfg.Checksums
+		c.Name = exp(c.Name)
+		out.Checksums = &c
+	}
+	if cfg.Packages != nil {
+		p := *cfg.Packages
+		p.Name = exp(p.Name)
+		p.Version = exp(p.Version)
+		p.Maintainer = exp(p.Maintainer)
+		p.Description = exp(p.Description)
+		p.Homepage = exp(p.Homepage)
+		p.License = exp(p.License)
+		p.Formats = dupList(p.Formats)
+		p.BinDir = exp(p.BinDir)
+		p.Depends = dupList(p.Depends)
+		p.Systemd = dupList(p.Systemd)
+		p.Configs = make(map[string]string, len(cfg.Packages.Configs))
+		for k, v := range cfg.Packages.Configs {
+			p.Configs[exp(k)] = exp(v)
+		}
+		p.Completions = Completions{Bash: exp(p.Completions.Bash), Zsh: exp(p.Completions.Zsh), Fish: exp(p.Completions.Fish)}
+		p.Scripts = PackageScripts{PostInstall: exp(p.Scripts.PostInstall), PreRemove: exp(p.Scripts.PreRemove),
+			PostRemove: exp(p.Scripts.PostRemove)}
+		if w := p.Windows; w != nil {
+			p.Windows = &WindowsPackage{Name: exp(w.Name), Publisher: exp(w.Publisher), InstallDir: expandBraced(w.InstallDir),
+				Icon: exp(w.Icon), LicenseFile: exp(w.LicenseFile), Shortcut: w.Shortcut}
+		}
+		p.MacOS = expMac(p.MacOS)
+		if sp := p.Snap; sp != nil {
+			p.Snap = &SnapPackage{Name: exp(sp.Name), Summary: exp(sp.Summary), Description: exp(sp.Description),
+				Base: exp(sp.Base), Grade: exp(sp.Grade), Confinement: exp(sp.Confinement), Plugs: dupList(sp.Plugs)}
+		}
+		if ai := p.AppImage; ai != nil {
+			p.AppImage = &AppImagePackage{Name: exp(ai.Name), Icon: exp(ai.Icon), Desktop: exp(ai.Desktop),
+				Categories: dupList(ai.Categories), GUI: ai.GUI}
+		}
+		if fp := p.Flatpak; fp != nil {
+			p.Flatpak = &FlatpakPackage{AppID: exp(fp.AppID), Name: exp(fp.Name), Runtime: exp(fp.Runtime),
+				RuntimeVersion: exp(fp.RuntimeVersion), SDK: exp(fp.SDK), FinishArgs: dupList(fp.FinishArgs),
+				Icon: exp(fp.Icon), Desktop: exp(fp.Desktop), Metainfo: exp(fp.Metainfo),
+				Categories: dupList(fp.Categories), Bundle: fp.Bundle}
+		}
+		out.Packages = &p
+	}
+	if cfg.Homebrew != nil {
+		h := *cfg.Homebrew
+		h.Name = exp(h.Name)
+		h.Binary = exp(h.Binary)
+		h.Description = exp(h.Description)
+		h.Homepage = exp(h.Homepage)
+		h.License = exp(h.License)
+		h.URL = exp(h.URL)
+		h.Test = dupList(h.Test)
+		h.Tap = exp(h.Tap)
+		h.Branch = exp(h.Branch)
+		h.Directory = exp(h.Directory)
+		h.CommitMessage = exp(h.CommitMessage)
+		out.Homebrew = &h
+	}
+	if cfg.Images != nil {
+		im := *cfg.Images
+		im.Repository = exp(im.Repository)
+		im.Binary = exp(im.Binary)
+		im.Base = exp(im.Base)
+		im.Tags = dupList(im.Tags)
+		im.Labels = dupMap(im.Labels)
+		im.Entrypoint = dupList(im.Entrypoint)
+		im.Cmd = dupList(im.Cmd)
+		im.User = exp(im.User)
+		im.Files = dupMap(im.Files)
+		out.Images = &im
+	}
+	if cfg.Provenance != nil {
+		p := *cfg.Provenance
+		p.Key = exp(p.Key)
+		out.Provenance = &p
+	}
+	if cfg.Sign != nil {
+		sg := *cfg.Sign
+		if sg.Cosign != nil {
+			c := *sg.Cosign
+			c.Key = exp(c.Key)
+			c.Artifacts = exp(c.Artifacts)
+			c.Args = dupList(c.Args)
+			sg.Cosign = &c
+		}
+		if sg.GPG != nil {
+			g := *sg.GPG
+			g.Key = exp(g.Key)
+			g.Artifacts = exp(g.Artifacts)
+			g.Args = dupList(g.Args)
+			sg.GPG = &g
+		}
+		if sg.Rekor != nil {
+			sg.Rekor = &RekorLog{URL: exp(sg.Rekor.URL)}
+		}
+		out.Sign = &sg
+	}
+	if cfg.Proxy != nil {
+		p := *cfg.Proxy
+		p.Dir = exp(p.Dir)
+		p.Addr = exp(p.Addr)
+		out.Proxy = &p
+	}
+
+	// assets
+	out.Assets = make([]AssetStep, len(cfg.Assets))
+	for i, a := range cfg.Assets {
+		a.Dir = exp(a.Dir)
+		a.Run = dupList(a.Run)
+		out.Assets[i] = a
+	}
 
 	// targets
 	out.Targets = make([]Target, len(cfg.Targets))
 	for i, t := range cfg.Targets {
-		out.Targets[i] = Target{
-			OS:     exp(t.OS),
-			Arch:   exp(t.Arch),
-			Output: exp(t.Output),
-			Env:    dupMap(t.Env),
+		t.OS = exp(t.OS)
+		t.Arch = exp(t.Arch)
+		t.Output = exp(t.Output)
+		t.Env = dupEnv(t.Env)
+		t.Compiler = exp(t.Compiler)
+		t.Tags = dupList(t.Tags)
+		t.LdFlags = dupList(t.LdFlags)
+		t.Vars = dupMap(t.Vars)
+		t.GcFlags = exp(t.GcFlags)
+		t.Timeout = exp(t.Timeout)
+		t.PGO = exp(t.PGO)
+		t.Variant = exp(t.Variant)
+		if t.TinyGo != nil {
+			tg := *t.TinyGo
+			tg.Target = exp(tg.Target)
+			t.TinyGo = &tg
+		}
+		if t.Docker != nil {
+			td := TargetDocker{Image: exp(t.Docker.Image), Platform: exp(t.Docker.Platform)}
+			if t.Docker.Setup != nil {
+				td.Setup = make([]string, len(t.Docker.Setup))
+				for j, c := range t.Docker.Setup {
+					td.Setup[j] = expandBraced(c)
+				}
+			}
+			t.Docker = &td
+		}
+		t.MacOS = expMac(t.MacOS)
+		if t.MacOS != nil && out.Packages == nil {
+			out.Packages = &PackagesSection{} // targets[*].macos alone enables packaging
 		}
+		out.Targets[i] = t
 	}
-	// docker env expansion
+	// docker: every string and list field, setup commands included
 	if cfg.Docker != nil {
 		d := *cfg.Docker
 		d.Image = exp(d.Image)
 		d.WorkDir = exp(d.WorkDir)
 		d.Shell = exp(d.Shell)
-		d.Env = dupMap(d.Env)
+		d.Fallback = exp(d.Fallback)
+		d.Runtime = exp(d.Runtime)
+		d.User = exp(d.User)
+		d.Namespace = exp(d.Namespace)
+		d.Context = exp(d.Context)
+		d.CopyBack = dupList(d.CopyBack)
+		d.Pull = exp(d.Pull)
+		d.PullPolicy = exp(d.PullPolicy)
+		d.Platform = exp(d.Platform)
+		d.Network = exp(d.Network)
+		d.Dockerfile = exp(d.Dockerfile)
+		d.CACerts = dupList(d.CACerts)
+		d.BuildArgs = dupMap(d.BuildArgs)
+		d.Timeout = exp(d.Timeout)
+		d.CacheModules = exp(d.CacheModules)
+		d.CacheBuild = exp(d.CacheBuild)
+		if d.Entrypoint != nil {
+			ep := exp(*d.Entrypoint)
+			d.Entrypoint = &ep
+		}
+		// setup runs in the container shell: only ${…} is ours, bare
+		// $VAR / $(cmd) / $? are left for the shell.
+		d.Setup = make([]string, len(cfg.Docker.Setup))
+		for i, c := range cfg.Docker.Setup {
+			d.Setup[i] = expandBraced(c)
+		}
+		d.ExtraArgs = dupList(d.ExtraArgs)
+		d.Security.Seccomp = exp(d.Security.Seccomp)
+		d.Secrets = make([]Secret, len(cfg.Docker.Secrets))
+		for i, sec := range cfg.Docker.Secrets {
+			d.Secrets[i] = Secret{ID: exp(sec.ID), File: exp(sec.File), Env: exp(sec.Env)}
+		}
+		if d.Auth != nil {
+			d.Auth = &DockerAuth{Registry: exp(d.Auth.Registry), Username: exp(d.Auth.Username),
+				Password: exp(d.Auth.Password), Config: exp(d.Auth.Config)}
+		}
+		d.Env = dupEnv(d.Env)
 		out.Docker = &d
 	}
 	return &out
 }
 
+// lookupDefault resolves VAR or VAR:-default against the environment.
+func lookupDefault(k string) string {
+	if i := strings.Index(k, ":-"); i >= 0 {
+		name, def := k[:i], k[i+2:]
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			return v
+		}
+		return def
+	}
+	return os.Getenv(k)
+}
+
+// expandBraced replaces only ${VAR} / ${VAR:-def}, leaving other $ forms.
+func expandBraced(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		b.WriteString(lookupDefault(s[i+2 : i+j]))
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
 /* ──────────────── Build-dir helpers ──────────────── */
 
 func ensureBuildDir(dir string) error {
@@ -258,19 +1159,196 @@ func diffEnv(base, cur map[string]string) map[string]string {
 	return out
 }
 
-func composeLdflags(ld StringList, vars map[string]string) string {
-	out := make([]string, len(ld))
-	copy(out, ld)
-	for k, v := range vars {
-		out = append(out, fmt.Sprintf("-X '%s=%s'", k, v))
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// composeLdflags joins plain ldflags, the structured linkmode/extldflags
+// fields and the -X vars into a single -ldflags value.
+func composeLdflags(b BuildSection) string {
+	out := make([]string, len(b.LdFlags))
+	copy(out, b.LdFlags)
+	if b.LinkMode != "" {
+		out = append(out, "-linkmode "+b.LinkMode)
 	}
+	if len(b.ExtLdFlags) > 0 {
+		out = append(out, "-extldflags "+quoteFlag(strings.Join(b.ExtLdFlags, " ")))
+	}
+	out = append(out, varFlags(b.Vars)...)
 	return strings.Join(out, " ")
 }
 
+// varFlags renders vars as -X 'name=value', sorted for stable output.
+func varFlags(vars map[string]string) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, "-X "+quoteFlag(k+"="+vars[k]))
+	}
+	return out
+}
+
+// quoteFlag quotes s the way cmd/go splits -ldflags: single quotes
+// unless s contains one, then double quotes.
+func quoteFlag(s string) string {
+	if strings.Contains(s, "'") {
+		return `"` + s + `"`
+	}
+	return "'" + s + "'"
+}
+
+// build returns the global build section with the target's overrides
+// applied.
+func (t Target) build(b BuildSection) BuildSection {
+	if len(t.Tags) > 0 {
+		b.Tags = t.Tags
+	}
+	if len(t.LdFlags) > 0 {
+		b.LdFlags = t.LdFlags
+	}
+	if len(t.Vars) > 0 {
+		vars := make(map[string]string, len(b.Vars)+len(t.Vars))
+		for k, v := range b.Vars {
+			vars[k] = v
+		}
+		for k, v := range t.Vars {
+			vars[k] = v
+		}
+		b.Vars = vars
+	}
+	if t.GcFlags != "" {
+		b.GcFlags = t.GcFlags
+	}
+	if t.TrimPath != nil {
+		b.TrimPath = *t.TrimPath
+	}
+	if t.Timeout != "" {
+		b.Timeout = t.Timeout
+	}
+	if t.Retries != nil {
+		b.Retries = *t.Retries
+	}
+	if t.PGO != "" {
+		b.PGO = t.PGO
+	}
+	if len(t.VerifyDynamicLibs) > 0 {
+		b.VerifyDynamicLibs = t.VerifyDynamicLibs
+	}
+	if t.MaxGlibc != "" {
+		b.MaxGlibc = t.MaxGlibc
+	}
+	if t.VerifyStripped != nil {
+		b.VerifyStripped = *t.VerifyStripped
+	}
+	if t.SmokeTest != "" {
+		b.SmokeTest = t.SmokeTest
+	}
+	return b
+}
+
 // wantStatic returns true if the target wants static linking.
+// WebAssembly modules have no linkage to check.
 func (t Target) wantStatic(global bool) bool {
+	if t.Arch == "wasm" {
+		return false
+	}
 	if t.VerifyStatic != nil {
 		return *t.VerifyStatic
 	}
 	return global
 }
+
+// compiler returns the per-target compiler, falling back to global.
+func (t Target) compiler(global string) string {
+	if t.Compiler != "" {
+		return t.Compiler
+	}
+	return global
+}
+
+// tinygo layers the per-target tinygo options over the global ones and
+// picks the wasm targets tinygo expects for js/wasm and wasip1/wasm.
+func (t Target) tinygo(global TinyGoSection) TinyGoSection {
+	out := global
+	if l := t.TinyGo; l != nil {
+		out.Target = firstNonEmpty(l.Target, out.Target)
+		out.Opt = firstNonEmpty(l.Opt, out.Opt)
+		out.GC = firstNonEmpty(l.GC, out.GC)
+		out.Scheduler = firstNonEmpty(l.Scheduler, out.Scheduler)
+		out.Panic = firstNonEmpty(l.Panic, out.Panic)
+		out.Format = firstNonEmpty(l.Format, out.Format)
+		out.NoDebug = out.NoDebug || l.NoDebug
+	}
+	if out.Target == "" && t.Arch == "wasm" {
+		switch t.OS {
+		case "js":
+			out.Target = "wasm"
+		case "wasip1":
+			out.Target = "wasip1"
+		}
+	}
+	if out.Format == "" && (out.Target == "wasm" || out.Target == "wasip1") {
+		out.Format = "wasm"
+	}
+	return out
+}
+
+// label names the target in progress output.
+func (t Target) label(cfg *Config) string {
+	if t.OS == "" {
+		if tg := t.tinygo(cfg.Build.TinyGo); tg.Target != "" {
+			return tg.Target
+		}
+	}
+	if v := t.variantDir(); v != "" {
+		return t.OS + "/" + t.Arch + "/" + v
+	}
+	return t.OS + "/" + t.Arch
+}
+
+// variantEnv maps an arch to the env var selecting its variant.
+var variantEnv = map[string]string{
+	"386": "GO386", "amd64": "GOAMD64", "arm": "GOARM", "arm64": "GOARM64",
+	"mips": "GOMIPS", "mipsle": "GOMIPS", "mips64": "GOMIPS64", "mips64le": "GOMIPS64",
+	"ppc64": "GOPPC64", "ppc64le": "GOPPC64", "riscv64": "GORISCV64", "wasm": "GOWASM",
+}
+
+// variantVar returns the GO* variable and value for t.Variant, accepting
+// "7" or "v7" for arm and "3" or "v3" for amd64.
+func (t Target) variantVar() (name, value string, err error) {
+	if t.Variant == "" {
+		return "", "", nil
+	}
+	name, ok := variantEnv[t.Arch]
+	if !ok {
+		return "", "", fmt.Errorf("variant: arch %q has no variants", t.Arch)
+	}
+	value = t.Variant
+	switch t.Arch {
+	case "arm":
+		value = strings.TrimPrefix(value, "v")
+	case "amd64":
+		value = "v" + strings.TrimPrefix(value, "v")
+	}
+	return name, value, nil
+}
+
+// variantDir is the output path element for t.Variant, e.g. v7 or v3.
+func (t Target) variantDir() string {
+	switch {
+	case t.Variant == "":
+		return ""
+	case t.Arch == "arm" || t.Arch == "amd64":
+		return "v" + strings.TrimPrefix(t.Variant, "v")
+	}
+	return strings.ReplaceAll(t.Variant, ",", "-")
+}
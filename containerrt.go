@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Container runtimes: docker, podman and nerdctl behind one interface
+   ------------------------------------------------------------------ */
+
+// containerRuntime is the CLI that runs the docker: section.
+type containerRuntime interface {
+	// Name is the runtime kind: docker | podman | nerdctl.
+	Name() string
+	// Bin is the executable invoked (podman may hide behind `docker`).
+	Bin() string
+	// Ping checks the CLI exists and its engine answers.
+	Ping() error
+	// DefaultUserNS is the --userns value used for docker.userns: auto.
+	DefaultUserNS() string
+	// UseContext selects a named context (docker) or connection (podman).
+	UseContext(name string) error
+	// Endpoint is the engine address the CLI talks to, "" for the default
+	// local engine.
+	Endpoint() (string, error)
+}
+
+type dockerRuntime struct{}
+
+func (dockerRuntime) Name() string          { return "docker" }
+func (dockerRuntime) Bin() string           { return "docker" }
+func (dockerRuntime) Ping() error           { return pingCLI("docker", "info", "--format", "{{.ServerVersion}}") }
+func (dockerRuntime) DefaultUserNS() string { return "" } // rootless docker already maps root → you
+func (dockerRuntime) UseContext(name string) error {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return fmt.Errorf("conflicts with DOCKER_HOST; unset one of them")
+	}
+	return os.Setenv("DOCKER_CONTEXT", name)
+}
+func (dockerRuntime) Endpoint() (string, error) {
+	if h := os.Getenv("DOCKER_HOST"); h != "" {
+		return h, nil
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil // reported by Ping
+	}
+	out, err := exec.Command("docker", "context", "inspect", "--format", "{{.Endpoints.docker.Host}}").CombinedOutput()
+	if err != nil && os.Getenv("DOCKER_CONTEXT") != "" {
+		return "", fmt.Errorf("docker context inspect: %s", strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return "", nil // a CLI without contexts talks to the local daemon
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// podmanRuntime also covers the podman-docker shim (bin == "docker").
+type podmanRuntime struct{ bin string }
+
+func (podmanRuntime) Name() string  { return "podman" }
+func (p podmanRuntime) Bin() string { return p.bin }
+func (p podmanRuntime) Ping() error {
+	return pingCLI(p.bin, "info", "--format", "{{.Version.Version}}")
+}
+func (podmanRuntime) DefaultUserNS() string {
+	if os.Getuid() > 0 {
+		return "keep-id"
+	}
+	return ""
+}
+func (podmanRuntime) UseContext(name string) error {
+	if os.Getenv("CONTAINER_HOST") != "" {
+		return fmt.Errorf("conflicts with CONTAINER_HOST; unset one of them")
+	}
+	return os.Setenv("CONTAINER_CONNECTION", name)
+}
+
+// Endpoint is CONTAINER_HOST or the URI of CONTAINER_CONNECTION. Without
+// either podman runs locally (on macOS and Windows in its own machine).
+func (p podmanRuntime) Endpoint() (string, error) {
+	if h := os.Getenv("CONTAINER_HOST"); h != "" {
+		return h, nil
+	}
+	conn := os.Getenv("CONTAINER_CONNECTION")
+	if conn == "" {
+		return "", nil
+	}
+	out, err := exec.Command(p.bin, "system", "connection", "list", "--format", "{{.Name}} {{.URI}}").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s system connection list: %w", p.bin, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, uri, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == conn {
+			return uri, nil
+		}
+	}
+	return "", fmt.Errorf("podman: no connection %q (podman system connection list)", conn)
+}
+
+type nerdctlRuntime struct{}
+
+func (nerdctlRuntime) Name() string { return "nerdctl" }
+func (nerdctlRuntime) Bin() string  { return "nerdctl" }
+func (nerdctlRuntime) Ping() error {
+	return pingCLI("nerdctl", "info", "--format", "{{.ServerVersion}}")
+}
+func (nerdctlRuntime) DefaultUserNS() string { return "" }
+func (nerdctlRuntime) UseContext(string) error {
+	return fmt.Errorf("nerdctl has no contexts (use docker.namespace, or CONTAINERD_ADDRESS for another socket)")
+}
+func (nerdctlRuntime) Endpoint() (string, error) { return "", nil } // always a local containerd socket
+
+// selectRuntime resolves docker.runtime. auto picks the first CLI found
+// in PATH (docker, podman, nerdctl), falling back to docker so dry-runs
+// still print something sensible on machines without any runtime.
+func selectRuntime(name string) (containerRuntime, error) {
+	switch name {
+	case "docker":
+		if dockerIsPodman() {
+			return podmanRuntime{bin: "docker"}, nil
+		}
+		return dockerRuntime{}, nil
+	case "podman":
+		return podmanRuntime{bin: "podman"}, nil
+	case "nerdctl":
+		return nerdctlRuntime{}, nil
+	case "", "auto":
+		for _, n := range []string{"docker", "podman", "nerdctl"} {
+			if _, err := exec.LookPath(n); err == nil {
+				return selectRuntime(n)
+			}
+		}
+		return dockerRuntime{}, nil
+	}
+	return nil, fmt.Errorf("docker.runtime: want auto | docker | podman | nerdctl, got %q", name)
+}
+
+// remoteEndpoint reports whether an engine address is on another
+// machine. Sockets and loopback addresses (Docker Desktop, podman
+// machine) count as local.
+func remoteEndpoint(addr string) (bool, error) {
+	if addr == "" {
+		return false, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return false, fmt.Errorf("engine address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "unix", "npipe", "fd":
+		return false, nil
+	case "tcp", "ssh", "http", "https":
+		h := u.Hostname()
+		if ip := net.ParseIP(h); h == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return false, nil
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("engine address %q: want unix://, npipe://, tcp:// or ssh://", addr)
+}
+
+// useEngine applies docker.context and flags the docker section remote
+// when the engine runs elsewhere, checking that nothing needs a host
+// bind mount.
+func useEngine(cfg *Config, rt containerRuntime) error {
+	c := cfg.Docker
+	if c.Context != "" {
+		if err := rt.UseContext(c.Context); err != nil {
+			return fmt.Errorf("docker.context: %w", err)
+		}
+	}
+	addr, err := rt.Endpoint()
+	if err != nil {
+		return err
+	}
+	if c.remote, err = remoteEndpoint(addr); err != nil || !c.remote {
+		return err
+	}
+	if problems := remoteProblems(cfg); len(problems) > 0 {
+		return fmt.Errorf("%s engine %s is remote; these need a local engine:\n  %s",
+			rt.Name(), addr, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// remoteProblems lists settings that bind-mount host files, which a
+// remote engine cannot see.
+func remoteProblems(cfg *Config) []string {
+	c := cfg.Docker
+	var p []string
+	if _, err := remoteBuildDir(cfg); err != nil {
+		p = append(p, err.Error())
+	}
+	if len(c.Secrets) > 0 {
+		p = append(p, "docker.secrets")
+	}
+	if len(c.CACerts) > 0 {
+		p = append(p, "docker.ca_certs (COPY them in a docker.dockerfile instead)")
+	}
+	if c.SSHAgent {
+		p = append(p, "docker.ssh_agent")
+	}
+	if proxyEnabled(cfg) {
+		p = append(p, "proxy.use")
+	}
+	if c.Network == "none" {
+		p = append(p, "docker.network: none (go-builder itself is mounted)")
+	}
+	if c.User != "" && c.User != "image" {
+		p = append(p, "docker.user (copied sources are owned by the image user)")
+	}
+	for key, v := range map[string]string{"docker.cache_modules": c.CacheModules, "docker.cache_build": c.CacheBuild} {
+		if v != "" && v != "false" && v != "true" {
+			p = append(p, key+": a host directory (use true for a volume)")
+		}
+	}
+	slices.Sort(p)
+	return p
+}
+
+// pingCLI runs a cheap info command with a timeout.
+func pingCLI(bin string, args ...string) error {
+	if _, err := exec.LookPath(bin); err != nil {
+		return fmt.Errorf("%s CLI not found in PATH", bin)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if hint := pingHint(msg); hint != "" {
+			msg += "\n  hint: " + hint
+		}
+		return fmt.Errorf("%s engine unreachable: %s", bin, msg)
+	}
+	return nil
+}
+
+// pingHint suggests a fix for the common reasons an engine doesn't answer.
+func pingHint(msg string) string {
+	desktop := runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	switch {
+	case strings.Contains(msg, "permission denied") && strings.Contains(msg, "docker.sock"):
+		return "add your user to the docker group (sudo usermod -aG docker $USER, then log in again) or use rootless docker"
+	case strings.Contains(msg, "Podman") || strings.Contains(msg, "podman"):
+		if desktop {
+			return "start the VM: podman machine start"
+		}
+		return "start the API socket: systemctl --user start podman.socket"
+	case strings.Contains(msg, "containerd.sock"):
+		return "start containerd (sudo systemctl start containerd), or set up rootless nerdctl: containerd-rootless-setuptool.sh install"
+	case strings.Contains(msg, "Is the docker daemon running"), strings.Contains(msg, "Cannot connect to the Docker daemon"):
+		if desktop {
+			return "start Docker Desktop"
+		}
+		return "start the daemon: sudo systemctl start docker"
+	}
+	return ""
+}
+
+// dockerIsPodman detects the podman-docker compatibility shim.
+func dockerIsPodman() bool {
+	out, err := exec.Command("docker", "--version").Output()
+	return err == nil && strings.Contains(strings.ToLower(string(out)), "podman")
+}
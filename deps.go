@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   `go-builder deps outdated`: what should we bump before a release?
+   ------------------------------------------------------------------ */
+
+// goModule is the subset of `go list -m -json` output we use.
+type goModule struct {
+	Path     string
+	Version  string
+	Main     bool
+	Indirect bool
+	Update   *struct{ Version string }
+	Replace  *struct{ Path, Version string }
+	Dir      string
+	Sum      string // h1: go.sum hash
+}
+
+// outdatedDep is one row of the report.
+type outdatedDep struct {
+	Path    string   `json:"path"`
+	Version string   `json:"version"`
+	Latest  string   `json:"latest,omitempty"`
+	Vulns   []string `json:"vulns,omitempty"`
+}
+
+func depsCmd(args []string, asJSON bool) error {
+	if len(args) != 1 || args[0] != "outdated" {
+		return fmt.Errorf("usage: go-builder deps outdated [--json]")
+	}
+	mods, err := listModules("-u")
+	if err != nil {
+		return err
+	}
+	vulns, vulnErr := moduleVulns()
+
+	rows := []outdatedDep{}
+	for _, m := range mods {
+		if m.Main || m.Indirect {
+			continue
+		}
+		r := outdatedDep{Path: m.Path, Version: m.Version, Vulns: vulns[m.Path]}
+		if m.Update != nil {
+			r.Latest = m.Update.Version
+		}
+		if r.Latest != "" || len(r.Vulns) > 0 {
+			rows = append(rows, r)
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	if vulnErr != nil {
+		fmt.Fprintf(os.Stderr, "note: %v\n", vulnErr)
+	}
+	if len(rows) == 0 {
+		fmt.Println("all direct dependencies are up to date")
+		return nil
+	}
+	fmt.Printf("%-50s %-20s %-20s %s\n", "module", "current", "latest", "vulnerabilities")
+	for _, r := range rows {
+		fmt.Printf("%-50s %-20s %-20s %s\n", r.Path, r.Version, firstNonEmpty(r.Latest, "-"), strings.Join(r.Vulns, ","))
+	}
+	return nil
+}
+
+// listModules runs `go list -m -json [flags] all`.
+func listModules(flags ...string) ([]goModule, error) {
+	args := append([]string{"list", "-m", "-json"}, flags...)
+	cmd := exec.Command(goBin, append(args, "all")...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -m: %w", err)
+	}
+	var mods []goModule
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m goModule
+		if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		mods = append(mods, m)
+	}
+	return mods, nil
+}
+
+// moduleVulns maps module path → OSV ids reported by govulncheck for
+// code actually reachable from ./... .
+func moduleVulns() (map[string][]string, error) {
+	if _, err := exec.LookPath("govulncheck"); err != nil {
+		return nil, errors.New("govulncheck not installed, vulnerabilities not checked")
+	}
+	out, _ := exec.Command("govulncheck", "-json", "./...").Output() // exits 3 on findings
+	res := map[string][]string{}
+	seen := map[string]bool{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var msg struct {
+			Finding *struct {
+				OSV   string `json:"osv"`
+				Trace []struct {
+					Module string `json:"module"`
+				} `json:"trace"`
+			} `json:"finding"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			break
+		}
+		if f := msg.Finding; f != nil && len(f.Trace) > 0 {
+			mod := f.Trace[0].Module
+			if key := mod + " " + f.OSV; !seen[key] {
+				seen[key] = true
+				res[mod] = append(res[mod], f.OSV)
+			}
+		}
+	}
+	for _, ids := range res {
+		sort.Strings(ids)
+	}
+	return res, nil
+}
+
+// depReport is one module in build_dir/deps.json.
+type depReport struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Replace string `json:"replace,omitempty"` // path@version it was replaced by
+}
+
+// writeDepsReport records the module versions that went into this build
+// as deps.txt and/or deps.json in the build dir.
+func writeDepsReport(cfg *Config, formats []string, dry bool) error {
+	for _, f := range formats {
+		if f != "txt" && f != "json" {
+			return fmt.Errorf("deps_report: want txt and/or json, got %q", f)
+		}
+	}
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: dependency report (%s)\ngo list -m -json all\n", strings.Join(formats, ", "))
+		return nil
+	}
+	mods, err := listModules()
+	if err != nil {
+		return err
+	}
+	rows := []depReport{}
+	for _, m := range mods {
+		if m.Main {
+			continue
+		}
+		r := depReport{Path: m.Path, Version: m.Version}
+		if m.Replace != nil {
+			r.Replace = strings.TrimSuffix(m.Replace.Path+"@"+m.Replace.Version, "@")
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Path < rows[j].Path })
+
+	for _, f := range formats {
+		var b []byte
+		if f == "json" {
+			if b, err = json.MarshalIndent(rows, "", "  "); err != nil {
+				return err
+			}
+			b = append(b, '\n')
+		} else {
+			var sb strings.Builder
+			for _, r := range rows {
+				fmt.Fprintf(&sb, "%s %s", r.Path, r.Version)
+				if r.Replace != "" {
+					fmt.Fprintf(&sb, " => %s", r.Replace)
+				}
+				sb.WriteString("\n")
+			}
+			b = []byte(sb.String())
+		}
+		path := filepath.Join(cfg.BuildDir, "deps."+f)
+		if err := os.WriteFile(path, b, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(textOut, "✔ %s (%d modules)\n", path, len(rows))
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/* ------------------------------------------------------------------
+   Per-target builder containers, run concurrently
+   ------------------------------------------------------------------ */
+
+// dockerGroup is one builder container: the docker settings shared by
+// its targets.
+type dockerGroup struct {
+	docker  *DockerSection
+	targets []string // target labels, passed as --target
+}
+
+func (g dockerGroup) name() string {
+	if g.docker.Platform != "" {
+		return dockerImage(g.docker) + " " + g.docker.Platform
+	}
+	return dockerImage(g.docker)
+}
+
+// dockerGroups splits the targets selected by sel by their effective
+// docker settings (targets[*].docker over the docker section). It returns
+// nil when no target overrides docker: the build runs in one container.
+func dockerGroups(cfg *Config, bins []*Config, sel []string) ([]dockerGroup, error) {
+	var all []buildJob
+	for _, b := range bins {
+		for _, t := range b.Targets {
+			all = append(all, buildJob{Cfg: b, Target: t})
+		}
+	}
+	if !slices.ContainsFunc(all, func(j buildJob) bool { return j.Target.Docker != nil }) {
+		return nil, nil
+	}
+	jobs, err := selectJobs(all, sel)
+	if err != nil {
+		return nil, err
+	}
+	var groups []dockerGroup
+	byKey := map[string]int{}
+	keyOf := map[string]string{} // target label → group key
+	for _, j := range jobs {
+		d := *cfg.Docker
+		if o := j.Target.Docker; o != nil {
+			d.Image = firstNonEmpty(o.Image, d.Image)
+			d.Platform = firstNonEmpty(o.Platform, d.Platform)
+			if o.Setup != nil {
+				d.Setup = o.Setup
+			}
+		}
+		label := j.Target.label(j.Cfg)
+		key := strings.Join(append([]string{dockerImage(&d), d.Platform}, d.Setup...), "\x00")
+		if k, ok := keyOf[label]; ok {
+			if k != key {
+				return nil, fmt.Errorf("target %s: binaries give it different docker settings", label)
+			}
+			continue
+		}
+		keyOf[label] = key
+		i, ok := byKey[key]
+		if !ok {
+			i = len(groups)
+			byKey[key] = i
+			groups = append(groups, dockerGroup{docker: &d})
+		}
+		groups[i].targets = append(groups[i].targets, label)
+	}
+	return groups, nil
+}
+
+// runDockerGroups runs the gates once in the docker section's container
+// (go-builder --gates-only), then builds every group in its own container
+// (--build-only), up to n at a time with prefixed output. The artifacts of
+// the groups that succeeded are then recorded, checksummed and signed by
+// one --finalize run in the docker section's container, so the manifest
+// is written once. Unless keepGoing is set, a failure stops new groups
+// and skips that run.
+func runDockerGroups(cfg *Config, rt containerRuntime, groups []dockerGroup, args string, n int, keepGoing, debug, dry bool) error {
+	if cfg.Docker.SetupQemu {
+		if err := setupQemu(rt, dry); err != nil {
+			return err
+		}
+		cfg.Docker.SetupQemu = false // not again for the --finalize run
+	}
+	for _, g := range groups {
+		d := g.docker
+		d.SetupQemu = false
+		if d.Shell == "auto" && dockerImage(d) == dockerImage(cfg.Docker) && d.Platform == cfg.Docker.Platform {
+			d.Shell = cfg.Docker.Shell // already probed
+		}
+		if err := resolveShell(d, rt, dry); err != nil {
+			return fmt.Errorf("%s: %w", g.name(), err)
+		}
+		if d.Network == "none" && selfBinary(d) == "" {
+			return fmt.Errorf("%s: docker.network: none: go-builder can't be installed in the container without network", g.name())
+		}
+	}
+	if hasGates(cfg) {
+		fmt.Fprintln(textOut, ">>> Gates")
+		if err := dockerRun(cfg, rt, innerScript(cfg.Docker, args+" --gates-only", nil), debug, dry); err != nil {
+			return err
+		}
+	}
+	// copying the project to a remote engine snapshots build_dir, so
+	// concurrent groups would copy back each other's stale files
+	if n < 1 || dry || debug || cfg.Docker.remote {
+		n = 1
+	}
+	n = min(n, len(groups))
+
+	errs := make([]error, len(groups))
+	started := make([]bool, len(groups))
+	var (
+		mu     sync.Mutex // serialises writes to stdout and stderr
+		wg     sync.WaitGroup
+		failed bool
+	)
+	next := make(chan int)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				g := groups[i]
+				gc := *cfg
+				gc.Docker = g.docker
+				var stdout, stderr io.Writer = textOut, os.Stderr
+				var o, e *prefixWriter
+				if n > 1 {
+					o = &prefixWriter{prefix: "[" + g.name() + "] ", out: textOut, mu: &mu}
+					e = &prefixWriter{prefix: "[" + g.name() + "] ", out: os.Stderr, mu: &mu}
+					stdout, stderr = o, e
+				}
+				text := stdout
+				var relay *eventRelay
+				if eventEnc != nil {
+					relay = &eventRelay{text: stderr}
+					stdout, text = relay, stderr
+				}
+				fmt.Fprintf(text, ">>> Builder container %s: %s\n", g.name(), strings.Join(g.targets, ", "))
+				errs[i] = dockerRunTo(&gc, rt, innerScript(g.docker, args+" --build-only", g.targets), debug, dry, stdout, stderr)
+				if relay != nil {
+					relay.Flush()
+				}
+				if o != nil {
+					o.Flush()
+					e.Flush()
+				}
+				if errs[i] != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range groups {
+		mu.Lock()
+		stop := failed && !keepGoing
+		mu.Unlock()
+		if stop {
+			break // let running containers finish, start no new ones
+		}
+		started[i] = true
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var done []string
+	var fails []error
+	for i, g := range groups {
+		if errs[i] != nil {
+			fails = append(fails, fmt.Errorf("%s: %w", g.name(), errs[i]))
+		} else if started[i] {
+			done = append(done, g.targets...)
+		}
+	}
+	if len(fails) > 0 && !keepGoing {
+		return errors.Join(fails...)
+	}
+	if len(done) > 0 {
+		fmt.Fprintln(textOut, ">>> Recording artifacts")
+		if err := dockerRun(cfg, rt, innerScript(cfg.Docker, args+" --finalize", done), debug, dry); err != nil {
+			return err
+		}
+	}
+	if len(fails) > 0 {
+		fmt.Fprintf(textOut, "\n✘ %d of %d builder containers failed:\n", len(fails), len(groups))
+		for _, err := range fails {
+			fmt.Fprintf(textOut, "  %v\n", err)
+		}
+		return fmt.Errorf("%d of %d builder containers failed", len(fails), len(groups))
+	}
+	return nil
+}
+
+// hasGates reports whether cfg enables a gate that runs before the build.
+func hasGates(cfg *Config) bool {
+	return cfg.Checks != nil || cfg.Test != nil || cfg.Licenses != nil || cfg.Bench != nil ||
+		(cfg.Modules != nil && cfg.Modules.Verify)
+}
+
+// recordExisting is the build step of --finalize: the artifacts built by
+// the --build-only containers go into the manifest.
+func recordExisting(jobs []buildJob, m *Manifest, dry bool) error {
+	if dry {
+		return nil
+	}
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			return fmt.Errorf("%s: %w", j.label(), err)
+		}
+		if err := recordArtifact(m, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
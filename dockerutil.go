@@ -1,24 +1,524 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 /* ------------------------------------------------------------------
-   Utilities to run a build inside Docker by shelling out to `docker`
+   Utilities to run a build inside a container by shelling out to the
+   selected runtime CLI (see containerrt.go)
    ------------------------------------------------------------------ */
 
 // dockerRun executes the given shell commands inside a disposable container.
-func dockerRun(cfg *Config, cmds []string, dry bool) error {
-	c := cfg.Docker
+// With debug the container is kept after a failure: on a terminal the
+// failed build drops into a shell inside it, otherwise the commands to
+// inspect it are printed.
+func dockerRun(cfg *Config, rt containerRuntime, cmds []string, debug, dry bool) error {
+	if eventEnc == nil {
+		return dockerRunTo(cfg, rt, cmds, debug, dry, os.Stdout, os.Stderr)
+	}
+	r := &eventRelay{text: os.Stderr}
+	defer r.Flush()
+	return dockerRunTo(cfg, rt, cmds, debug, dry, r, os.Stderr)
+}
 
-	image := c.Image
-	if image == "" {
-		image = "docker.io/golang:latest"
+// containerSeq keeps container names unique across concurrent runs.
+var containerSeq atomic.Int64
+
+// dockerRunTo is dockerRun with the container output sent to stdout and
+// stderr.
+func dockerRunTo(cfg *Config, rt containerRuntime, cmds []string, debug, dry bool, stdout, stderr io.Writer) error {
+	if cfg.Docker.SetupQemu {
+		if err := setupQemu(rt, dry); err != nil {
+			return err
+		}
+	}
+	script := strings.Join(cmds, " && ")
+	tty := debug && isTerminal(os.Stdin)
+	if tty {
+		shell := firstNonEmpty(cfg.Docker.Shell, "sh")
+		script = fmt.Sprintf(`( %s ) || { s=$?; echo "go-builder: build failed (exit $s); debug shell, exit to leave"; %s; exit $s; }`, script, shell)
+	}
+	runArgs, cleanup, err := dockerArgs(cfg, rt, script, tty, dry)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	name := fmt.Sprintf("go-builder-%d-%d", os.Getpid(), containerSeq.Add(1))
+	if debug {
+		name = strings.Replace(name, "go-builder-", "go-builder-debug-", 1)
+	}
+	if debug || cfg.Docker.remote {
+		runArgs = keepContainer(runArgs, name)
+	}
+	var sync *remoteSync
+	if cfg.Docker.remote {
+		if sync, err = newRemoteSync(cfg, rt, name); err != nil {
+			return err
+		}
+		runArgs[0] = "create"
+	}
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: %s %s\n", rt.Bin(), strings.Join(runArgs, " "))
+		if sync != nil {
+			sync.dryRun(tty)
+		}
+		return nil
+	}
+	timeout, err := parseTimeout(cfg.Docker.Timeout)
+	if err != nil {
+		return fmt.Errorf("docker.%w", err)
+	}
+	retries := cfg.Docker.Retries
+	if debug {
+		retries = 0 // the kept container's name is taken
+		if tty {
+			timeout = 0 // don't kill the debug shell
+		}
+	}
+	if tty {
+		fmt.Fprintf(textOut, "container %s; from another terminal: %s exec -it %s %s\n",
+			name, rt.Bin(), name, firstNonEmpty(cfg.Docker.Shell, "sh"))
+	}
+	if vals := secretValues(cfg.Docker.Secrets); len(vals) > 0 && !tty {
+		o, e := &redactWriter{w: stdout, secrets: vals}, &redactWriter{w: stderr, secrets: vals}
+		defer o.Flush()
+		defer e.Flush()
+		stdout, stderr = o, e
+	}
+	if sync != nil {
+		if err := sync.copyIn(runArgs); err != nil {
+			return err
+		}
+		runArgs = sync.startArgs(tty)
+	}
+	err = runRetry(func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, rt.Bin(), runArgs...)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		if tty {
+			cmd.Stdin = os.Stdin
+		}
+		return cmd
+	}, timeout, retries, stdout)
+	if err != nil {
+		err = shellMissing(cfg.Docker, rt, err)
+	}
+	if sync != nil {
+		if cerr := sync.copyBack(); err == nil {
+			err = cerr
+		}
+		if !debug {
+			exec.Command(rt.Bin(), "rm", "-f", name).Run()
+		}
+	}
+	if !debug {
+		return err
+	}
+	if err == nil {
+		exec.Command(rt.Bin(), "rm", "-f", name).Run()
+		return nil
+	}
+	fmt.Fprintf(textOut, "\ncontainer %s kept for debugging:\n", name)
+	if !tty {
+		fmt.Fprintf(textOut, "  %s commit %s %s && %s run --rm -it --entrypoint %s %s\n",
+			rt.Bin(), name, name, rt.Bin(), firstNonEmpty(cfg.Docker.Shell, "sh"), name)
+	}
+	fmt.Fprintf(textOut, "  %s rm %s   # when done\n", rt.Bin(), name)
+	return err
+}
+
+// remoteSync moves the project to a container on a remote engine and
+// the build directory back, in place of the bind mount.
+type remoteSync struct {
+	rt             containerRuntime
+	name           string
+	workdir        string
+	buildDir, back string   // local build dir, its path in the container
+	globs          []string // docker.copy_back
+}
+
+func newRemoteSync(cfg *Config, rt containerRuntime, name string) (*remoteSync, error) {
+	rel, err := remoteBuildDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	workdir := firstNonEmpty(cfg.Docker.WorkDir, "/work")
+	return &remoteSync{rt: rt, name: name, workdir: workdir,
+		buildDir: rel, back: path.Join(workdir, filepath.ToSlash(rel)), globs: cfg.Docker.CopyBack}, nil
+}
+
+// remoteBuildDir is build_dir relative to the project, which is all a
+// remote build can copy back.
+func remoteBuildDir(cfg *Config) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(cfg.BuildDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(cwd, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || rel == ".." {
+		return "", fmt.Errorf("build_dir %s must be a subdirectory of the project", cfg.BuildDir)
+	}
+	return rel, nil
+}
+
+func (s *remoteSync) startArgs(tty bool) []string {
+	if tty {
+		return []string{"start", "-ai", s.name}
+	}
+	return []string{"start", "-a", s.name}
+}
+
+func (s *remoteSync) dryRun(tty bool) {
+	bin := s.rt.Bin()
+	fmt.Fprintf(textOut, "%s cp ./. %s:%s\n", bin, s.name, s.workdir)
+	fmt.Fprintf(textOut, "%s %s\n", bin, strings.Join(s.startArgs(tty), " "))
+	fmt.Fprintf(textOut, "%s cp %s:%s/. %s\n", bin, s.name, s.back, s.buildDir)
+	if len(s.globs) > 0 {
+		fmt.Fprintf(textOut, "%s cp %s:%s - | (extract %s)\n", bin, s.name, s.workdir, strings.Join(s.globs, " "))
+	}
+	fmt.Fprintf(textOut, "%s rm -f %s\n", bin, s.name)
+}
+
+// copyIn creates the container and copies the project into its workdir.
+func (s *remoteSync) copyIn(createArgs []string) error {
+	fmt.Fprintf(textOut, ">>> Copying the project to %s\n", s.name)
+	if out, err := exec.Command(s.rt.Bin(), createArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s create: %v: %s", s.rt.Bin(), err, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command(s.rt.Bin(), "cp", "./.", s.name+":"+s.workdir).CombinedOutput(); err != nil {
+		exec.Command(s.rt.Bin(), "rm", "-f", s.name).Run()
+		return fmt.Errorf("%s cp: %v: %s", s.rt.Bin(), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// copyBack fetches the build directory, also after a failed build so
+// --keep-going results and logs arrive.
+func (s *remoteSync) copyBack() error {
+	if out, err := exec.Command(s.rt.Bin(), "cp", s.name+":"+s.back+"/.", s.buildDir).CombinedOutput(); err != nil {
+		msg := strings.TrimSpace(string(out))
+		if strings.Contains(msg, "No such") || strings.Contains(msg, "no such") {
+			return nil // nothing was built (e.g. the go mod download warm-up)
+		}
+		return fmt.Errorf("%s cp %s: %v: %s", s.rt.Bin(), s.buildDir, err, msg)
+	}
+	fmt.Fprintf(textOut, "✔ copied %s back from %s\n", s.buildDir, s.name)
+	if len(s.globs) == 0 {
+		return nil
+	}
+	return s.copyGlobs()
+}
+
+// copyBackMatch reports whether rel, a slash path under the workdir, or
+// one of its parent directories matches a copy_back glob.
+func copyBackMatch(globs []string, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		for _, g := range globs {
+			if ok, _ := path.Match(g, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// copyGlobs extracts the docker.copy_back files from the container's
+// workdir. docker cp takes no patterns, so the workdir is streamed as a
+// tar and filtered here.
+func (s *remoteSync) copyGlobs() error {
+	cmd := exec.Command(s.rt.Bin(), "cp", s.name+":"+s.workdir, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	n, err := extractMatching(tar.NewReader(out), s.globs)
+	io.Copy(io.Discard, out)
+	if werr := cmd.Wait(); err == nil && werr != nil {
+		err = fmt.Errorf("%v: %s", werr, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("docker.copy_back: %w", err)
+	}
+	fmt.Fprintf(textOut, "✔ copied %d files matching docker.copy_back from %s\n", n, s.name)
+	return nil
+}
+
+// extractMatching writes the regular files of tr matching globs below the
+// current directory. Entry names start with the workdir's base name.
+func extractMatching(tr *tar.Reader, globs []string) (int, error) {
+	n := 0
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		_, rel, _ := strings.Cut(path.Clean(h.Name), "/")
+		if h.Typeflag != tar.TypeReg || rel == "" || !filepath.IsLocal(rel) || !copyBackMatch(globs, rel) {
+			continue
+		}
+		dst := filepath.FromSlash(rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return n, err
+		}
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, h.FileInfo().Mode().Perm())
+		if err != nil {
+			return n, err
+		}
+		_, err = io.Copy(f, tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// shellCandidates are the shells docker.shell: auto looks for, in order.
+var shellCandidates = []string{"bash", "sh", "ash", "dash", "zsh"}
+
+// imageHasShell reports whether shell runs in the builder image.
+func imageHasShell(c *DockerSection, rt containerRuntime, shell string) bool {
+	pa, _ := platformArgs(c)
+	args := append(append([]string{"run", "--rm", "--entrypoint", shell}, pa...), dockerImage(c), "-c", "true")
+	return exec.Command(rt.Bin(), args...).Run() == nil
+}
+
+// imageShells lists the candidate shells the builder image has.
+func imageShells(c *DockerSection, rt containerRuntime) []string {
+	var found []string
+	for _, sh := range shellCandidates {
+		if imageHasShell(c, rt, sh) {
+			found = append(found, sh)
+		}
+	}
+	return found
+}
+
+// resolveShell replaces docker.shell: auto with the first shell the
+// image has, bash before sh.
+func resolveShell(c *DockerSection, rt containerRuntime, dry bool) error {
+	if c.Shell != "auto" {
+		return nil
+	}
+	if dry {
+		c.Shell = "<" + strings.Join(shellCandidates, "|") + ">"
+		return nil
 	}
+	for _, sh := range shellCandidates {
+		if imageHasShell(c, rt, sh) {
+			fmt.Fprintf(textOut, "docker.shell: auto → %s\n", sh)
+			c.Shell = sh
+			return nil
+		}
+	}
+	return fmt.Errorf("docker.shell: auto: image %s has none of %s; the build needs an image with a shell (not distroless or scratch)",
+		dockerImage(c), strings.Join(shellCandidates, ", "))
+}
+
+// shellMissing explains a run that exited 127 because docker.shell is not
+// in the image, listing the shells that are. Other errors pass through.
+func shellMissing(c *DockerSection, rt containerRuntime, err error) error {
+	var exit *exec.ExitError
+	shell := firstNonEmpty(c.Shell, "sh")
+	if !errors.As(err, &exit) || exit.ExitCode() != 127 || imageHasShell(c, rt, shell) {
+		return err
+	}
+	avail := "none"
+	if found := imageShells(c, rt); len(found) > 0 {
+		avail = strings.Join(found, ", ")
+	}
+	return fmt.Errorf("docker.shell %s: not found in image %s (available: %s); set docker.shell or use shell: auto",
+		shell, dockerImage(c), avail)
+}
+
+// keepContainer swaps --rm in run arguments for a fixed container name.
+func keepContainer(args []string, name string) []string {
+	out := make([]string, 0, len(args)+1)
+	for _, a := range args {
+		if a == "--rm" {
+			out = append(out, "--name", name)
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// isTerminal reports whether f is an interactive tty: a character device
+// other than the null device.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// selfContainerPath is where the running go-builder is mounted.
+const selfContainerPath = "/go-builder/go-builder"
+
+// selfBinary is the running executable when it can run in the builder
+// container as is: a statically linked linux binary of the container's
+// architecture. Otherwise "" and the container installs go-builder.
+func selfBinary(c *DockerSection) string {
+	if runtime.GOOS != "linux" || c.remote {
+		return ""
+	}
+	if c.Platform != "" {
+		_, arch, _ := strings.Cut(c.Platform, "/")
+		if arch, _, _ = strings.Cut(arch, "/"); arch != runtime.GOARCH {
+			return ""
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return ""
+	}
+	if bin, err := readBinary(exe); err != nil || len(bin.Libs) > 0 {
+		return "" // dynamically linked: the image may lack its libc
+	}
+	return exe
+}
+
+// prepareNoNetwork readies a docker.network: none build: go-builder must
+// be mounted since it can't be installed, and a docker.cache_modules
+// cache is filled by a networked `go mod download` run first (skipped
+// with --offline, when the cache must already be complete).
+func prepareNoNetwork(cfg *Config, rt containerRuntime, offline, dry bool) error {
+	if selfBinary(cfg.Docker) == "" {
+		return fmt.Errorf("docker.network: none: go-builder can't be installed in the container without " +
+			"network; run a statically linked linux go-builder (CGO_ENABLED=0) of the container's architecture")
+	}
+	if cfg.Docker.CacheModules == "" || offline {
+		return nil
+	}
+	warm := *cfg
+	d := *cfg.Docker
+	d.Network = ""
+	warm.Docker = &d
+	fmt.Fprintln(textOut, ">>> Filling the module cache")
+	if err := dockerRun(&warm, rt, append(append([]string{}, d.Setup...), "go mod download"), false, dry); err != nil {
+		return fmt.Errorf("docker.cache_modules: go mod download: %w", err)
+	}
+	cfg.Docker.SetupQemu = false // done by the warm-up run
+	return nil
+}
+
+// builderInstall is the command installing this go-builder version in
+// the container, and the path it lands at.
+func builderInstall() (cmd, bin string) {
+	v := builderVersion()
+	if v == "(devel)" || strings.HasSuffix(v, "+dirty") {
+		v = "latest" // built from a local checkout: no published version to pin
+	}
+	return "go install github.com/pablolagos/go-builder@" + v, `"$(go env GOPATH)/bin/go-builder"`
+}
+
+// innerScript is the container script: docker.setup, installing
+// go-builder unless it is mounted, then go-builder with args for targets.
+func innerScript(d *DockerSection, args string, targets []string) []string {
+	script := append([]string{}, d.Setup...)
+	self := selfContainerPath
+	if selfBinary(d) == "" {
+		var install string
+		install, self = builderInstall()
+		script = append(script, install)
+	}
+	cmd := self + args
+	for _, t := range targets {
+		cmd += " --target=" + shellQuote(t)
+	}
+	return append(script, cmd)
+}
+
+// qemuImage registers QEMU user-mode emulators with the host kernel.
+const qemuImage = "docker.io/multiarch/qemu-user-static"
+
+// setupQemu registers binfmt_misc handlers for every architecture QEMU
+// emulates (docker.setup_qemu), so foreign-platform builder containers
+// and smoke tests run. It needs a privileged container.
+func setupQemu(rt containerRuntime, dry bool) error {
+	args := []string{"run", "--rm", "--privileged", qemuImage, "--reset", "-p", "yes"}
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: %s %s\n", rt.Bin(), strings.Join(args, " "))
+		return nil
+	}
+	fmt.Fprintln(textOut, ">>> Registering QEMU binfmt handlers")
+	out, err := exec.Command(rt.Bin(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker.setup_qemu: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// dockerShell starts an interactive shell in the builder container with
+// the same mounts and env as a build, after running docker.setup.
+func dockerShell(cfg *Config, rt containerRuntime, dry bool) error {
+	if cfg.Docker.SetupQemu {
+		if err := setupQemu(rt, dry); err != nil {
+			return err
+		}
+	}
+	shell := firstNonEmpty(cfg.Docker.Shell, "sh")
+	script := append(append([]string{}, cfg.Docker.Setup...), "exec "+shell)
+	runArgs, cleanup, err := dockerArgs(cfg, rt, strings.Join(script, " && "), true, dry)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: %s %s\n", rt.Bin(), strings.Join(runArgs, " "))
+		return nil
+	}
+	cmd := exec.Command(rt.Bin(), runArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return shellMissing(cfg.Docker, rt, cmd.Run())
+}
+
+// dockerArgs assembles `run` arguments executing script with the shell.
+// cleanup removes temporary files backing the run and must always be called.
+func dockerArgs(cfg *Config, rt containerRuntime, script string, interactive, dry bool) (args []string, cleanup func(), err error) {
+	c := cfg.Docker
+	cleanup = func() {}
+
+	image := dockerImage(c)
 	workdir := c.WorkDir
 	if workdir == "" {
 		workdir = "/work"
@@ -28,24 +528,811 @@ func dockerRun(cfg *Config, cmds []string, dry bool) error {
 		shell = "sh"
 	}
 
-	hostDir, _ := os.Getwd()
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, cleanup, err
+	}
+	hostDir, err := hostMountPath(cwd, rt.Bin())
+	if err != nil {
+		return nil, cleanup, err
+	}
 	mount := fmt.Sprintf("%s:%s", hostDir, workdir)
+	label, err := selinuxLabel(c.SELinux)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if label != "" {
+		mount += ":" + label
+	}
 
 	// Merge env layers: host env kept, global env + docker.env appended.
+	// Global from_file values are read by go-builder inside the container;
+	// docker.env is not seen there, so its files are read here.
 	envArgs := []string{}
-	for k, v := range mergeEnvLayers(nil, cfg.Env, c.Env) {
-		envArgs = append(envArgs, "-e", fmt.Sprintf("%s=%s", k, v))
+	fileEnv, err := dockerFileEnv(c, dry)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	for k, v := range mergeEnvLayers(nil, cfg.Env.literals(), c.Env.literals()) {
+		if _, ok := fileEnv[k]; !ok {
+			envArgs = append(envArgs, "-e", fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	for _, k := range sortedKeys(fileEnv) {
+		envArgs = append(envArgs, "-e", k)
+	}
+	if v := os.Getenv("SOURCE_DATE_EPOCH"); v != "" { // git may be missing in the image
+		envArgs = append(envArgs, "-e", "SOURCE_DATE_EPOCH="+v)
+	}
+	envArgs = append(envArgs, proxyEnvArgs(cfg)...)
+	runArgs := []string{"run", "--rm", "-w", workdir}
+	if !c.remote {
+		runArgs = append(runArgs, "-v", mount)
+	}
+	if interactive {
+		runArgs = append(runArgs, "-it")
+	}
+	if c.Network != "" {
+		runArgs = append(runArgs, "--network", c.Network)
+	}
+	if len(c.CACerts) > 0 {
+		ca, err := caCertArgs(c.CACerts, rt, label)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		runArgs = append(runArgs, ca...)
+		// OpenSSL users (git, curl, apt) need the system store updated;
+		// that needs root, Go reads SSL_CERT_DIR regardless
+		script = "{ update-ca-certificates >/dev/null 2>&1 || true; }; " + script
+	}
+	if c.SSHAgent {
+		sock, err := sshAgentSocket(rt, dry)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		runArgs = append(runArgs, "-v", sock+":"+sshAgentContainerSock,
+			"-e", "SSH_AUTH_SOCK="+sshAgentContainerSock,
+			"-e", "GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=accept-new")
+	}
+	pa, err := platformArgs(c)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	runArgs = append(runArgs, pa...)
+	switch c.Pull {
+	case "":
+	case "always", "missing", "never":
+		runArgs = append(runArgs, "--pull="+c.Pull)
+	default:
+		return nil, cleanup, fmt.Errorf("docker.pull: want always | missing | never, got %q", c.Pull)
+	}
+	ns := userNamespace(c.UserNS, rt)
+	if ns != "" && rt.Name() == "nerdctl" {
+		return nil, cleanup, fmt.Errorf("docker.userns: nerdctl has no --userns (use rootless containerd instead)")
+	}
+	if ns != "" {
+		runArgs = append(runArgs, "--userns="+ns)
+	}
+	userMode := c.User
+	if c.remote && userMode == "" {
+		userMode = "image" // the copied sources belong to the image user
+	}
+	user, err := containerUser(userMode, rt)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if user != "" {
+		// no passwd entry for this uid: give it a writable home and Go dirs
+		runArgs = append(runArgs, "--user", user, "-e", "HOME=/tmp", "-e", "GOPATH=/tmp/go", "-e", "GOCACHE=/tmp/go-cache")
+	}
+	if c.Init {
+		runArgs = append(runArgs, "--init")
+	}
+	if c.Entrypoint != nil {
+		runArgs = append(runArgs, "--entrypoint="+*c.Entrypoint)
+	}
+	if exe := selfBinary(c); exe != "" {
+		src, err := hostMountPath(exe, rt.Bin())
+		if err != nil {
+			return nil, cleanup, err
+		}
+		opts := "ro"
+		if label != "" {
+			opts += "," + label
+		}
+		runArgs = append(runArgs, "-v", src+":"+selfContainerPath+":"+opts)
 	}
-	runArgs := append([]string{
-		"run", "--rm", "-w", workdir, "-v", mount,
-	}, envArgs...)
-	runArgs = append(runArgs, image, shell, "-c", strings.Join(cmds, " && "))
+	runArgs = append(runArgs, envArgs...)
 
+	secretArgs, cleanup, err := secretMounts(c.Secrets, label, dry)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	runArgs = append(runArgs, secretArgs...)
+	if proxyEnabled(cfg) {
+		dir, err := filepath.Abs(proxyDir(cfg))
+		if err != nil {
+			return nil, cleanup, err
+		}
+		if dir, err = hostMountPath(dir, rt.Bin()); err != nil {
+			return nil, cleanup, err
+		}
+		opts := "ro"
+		if label != "" {
+			opts += "," + label
+		}
+		runArgs = append(runArgs, "-v", dir+":"+proxyContainerDir+":"+opts,
+			"-e", "GOPROXY="+proxyURL(proxyContainerDir))
+	}
+	runArgs = append(runArgs, securityArgs(c.Security)...)
+	// after securityArgs: a configured cache wins over the read-only /tmp default
+	for _, cm := range []struct{ key, setting, volume, dir, env string }{
+		{"cache_modules", c.CacheModules, modCacheVolume, modCacheContainerDir, "GOMODCACHE"},
+		{"cache_build", c.CacheBuild, buildCacheVolume, buildCacheContainerDir, "GOCACHE"},
+	} {
+		m, err := cacheMount(cm.setting, cm.volume, cm.dir, label, rt, rt.Name() == "podman" && ns == "keep-id", dry)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("docker.%s: %w", cm.key, err)
+		}
+		if m != "" && user != "" && cm.setting == "true" && !dry {
+			if err := ownVolume(rt, image, pa, cm.volume, user); err != nil {
+				return nil, cleanup, fmt.Errorf("docker.%s: %w", cm.key, err)
+			}
+		}
+		if m != "" {
+			runArgs = append(runArgs, "-v", m, "-e", cm.env+"="+cm.dir)
+		}
+	}
+	runArgs = append(runArgs, c.ExtraArgs...)
+	return append(runArgs, image, shell, "-c", script), cleanup, nil
+}
+
+// securityArgs maps docker.security onto run flags. A read-only rootfs
+// gets a writable, executable /tmp (unless tmpfs is set) and the Go
+// caches are moved there so `go install` and `go build` still work.
+func securityArgs(s DockerSecurity) []string {
+	var args []string
+	for _, c := range s.CapDrop {
+		args = append(args, "--cap-drop", c)
+	}
+	for _, c := range s.CapAdd {
+		args = append(args, "--cap-add", c)
+	}
+	if s.Seccomp != "" {
+		profile := s.Seccomp
+		if profile != "unconfined" {
+			if abs, err := filepath.Abs(profile); err == nil {
+				profile = abs
+			}
+		}
+		args = append(args, "--security-opt", "seccomp="+profile)
+	}
+	if s.NoNewPrivileges {
+		args = append(args, "--security-opt", "no-new-privileges")
+	}
+	tmpfs := s.Tmpfs
+	if s.ReadOnly {
+		args = append(args, "--read-only")
+		if len(tmpfs) == 0 {
+			tmpfs = []string{"/tmp:exec"}
+		}
+		args = append(args, "-e", "HOME=/tmp", "-e", "GOPATH=/tmp/go", "-e", "GOCACHE=/tmp/go-cache")
+	}
+	for _, t := range tmpfs {
+		args = append(args, "--tmpfs", t)
+	}
+	return args
+}
+
+// secretMounts exposes each secret read-only at /run/secrets/<id>, the path
+// BuildKit uses for --mount=type=secret, so setup steps can read tokens
+// without them entering the process env or an image layer. Env-sourced
+// secrets are staged in a private temp file removed by cleanup.
+func secretMounts(secrets []Secret, label string, dry bool) (args []string, cleanup func(), err error) {
+	var tmp []string
+	cleanup = func() {
+		for _, f := range tmp {
+			os.Remove(f)
+		}
+	}
+	opts := "ro"
+	if label != "" {
+		opts += "," + label
+	}
+	for _, sec := range secrets {
+		if sec.ID == "" || (sec.File == "") == (sec.Env == "") {
+			return nil, cleanup, fmt.Errorf("docker.secrets: each entry needs an id and exactly one of file or env")
+		}
+		src := sec.File
+		switch {
+		case sec.Env != "" && dry:
+			src = "<$" + sec.Env + ">"
+		case sec.Env != "":
+			v, ok := os.LookupEnv(sec.Env)
+			if !ok {
+				return nil, cleanup, fmt.Errorf("docker.secrets %s: $%s is not set", sec.ID, sec.Env)
+			}
+			f, err := os.CreateTemp(secretTempDir(), "go-builder-secret-*")
+			if err != nil {
+				return nil, cleanup, err
+			}
+			tmp = append(tmp, f.Name())
+			_, err = f.WriteString(v)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return nil, cleanup, err
+			}
+			src = f.Name()
+		default:
+			if abs, err := filepath.Abs(src); err == nil {
+				src = abs
+			}
+		}
+		args = append(args, "-v", fmt.Sprintf("%s:/run/secrets/%s:%s", src, sec.ID, opts))
+	}
+	return args, cleanup, nil
+}
+
+// normalizeDocker folds pull_policy into pull and turns digest
+// verification on for images pinned by digest.
+func normalizeDocker(c *DockerSection) error {
+	switch c.Fallback {
+	case "", "fail", "warn", "local":
+	default:
+		return fmt.Errorf("docker.fallback: want fail | warn | local, got %q", c.Fallback)
+	}
+	for _, g := range c.CopyBack {
+		if _, err := path.Match(g, ""); err != nil || !filepath.IsLocal(g) {
+			return fmt.Errorf("docker.copy_back: %q is not a glob under the workdir", g)
+		}
+	}
+	if c.PullPolicy != "" {
+		pull, ok := map[string]string{"always": "always", "if-not-present": "missing", "never": "never"}[c.PullPolicy]
+		if !ok {
+			return fmt.Errorf("docker.pull_policy: want always | if-not-present | never, got %q", c.PullPolicy)
+		}
+		if c.Pull != "" && c.Pull != pull {
+			return fmt.Errorf("docker.pull_policy %s conflicts with docker.pull %s", c.PullPolicy, c.Pull)
+		}
+		c.Pull = pull
+	}
+	if strings.Contains(c.Image, "@sha256:") {
+		c.VerifyDigest = true
+	}
+	if c.Dockerfile != "" {
+		if c.VerifyDigest {
+			return fmt.Errorf("docker.dockerfile: a locally built image has no registry digest to verify")
+		}
+		if c.Image == "" {
+			abs, err := filepath.Abs(c.Dockerfile)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256([]byte(abs))
+			c.Image = "localhost/go-builder-" + hex.EncodeToString(sum[:4])
+		}
+		// the image exists only locally: never pull it, but let the
+		// base image be refreshed when asked
+		c.pullBase = c.Pull == "always"
+		c.Pull = "never"
+	}
+	return nil
+}
+
+// buildImage builds docker.dockerfile into docker.image. It runs every
+// time; the runtime's layer cache makes an unchanged Dockerfile cheap.
+func buildImage(c *DockerSection, rt containerRuntime, dry bool) error {
+	if c.SetupQemu { // RUN steps of a foreign platform need it too
+		if err := setupQemu(rt, dry); err != nil {
+			return err
+		}
+		c.SetupQemu = false
+	}
+	file, err := filepath.Abs(c.Dockerfile)
+	if err != nil {
+		return err
+	}
+	args := []string{"build", "-f", file, "-t", c.Image}
+	pa, err := platformArgs(c)
+	if err != nil {
+		return err
+	}
+	args = append(args, pa...)
+	if c.pullBase {
+		args = append(args, "--pull")
+	}
+	keys := make([]string, 0, len(c.BuildArgs))
+	for k := range c.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--build-arg", k+"="+c.BuildArgs[k])
+	}
+	sa, err := buildSecretArgs(c.Secrets)
+	if err != nil {
+		return err
+	}
+	args = append(args, sa...)
+	args = append(args, filepath.Dir(file))
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: %s %s\n", rt.Bin(), strings.Join(args, " "))
+		return nil
+	}
+	fmt.Fprintf(textOut, ">>> Building builder image %s\n", c.Image)
+	cmd := exec.Command(rt.Bin(), args...)
+	cmd.Stdout, cmd.Stderr = textOut, os.Stderr
+	if len(sa) > 0 {
+		cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1") // --secret needs BuildKit
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker.dockerfile: %s build: %w", rt.Bin(), err)
+	}
+	return nil
+}
+
+// buildSecretArgs exposes docker.secrets to RUN --mount=type=secret steps
+// of docker.dockerfile. The build client reads them on the host, so no
+// value is staged or passed on the command line.
+func buildSecretArgs(secrets []Secret) ([]string, error) {
+	var args []string
+	for _, sec := range secrets {
+		if sec.ID == "" || (sec.File == "") == (sec.Env == "") {
+			return nil, fmt.Errorf("docker.secrets: each entry needs an id and exactly one of file or env")
+		}
+		if sec.Env != "" {
+			args = append(args, "--secret", "id="+sec.ID+",env="+sec.Env)
+			continue
+		}
+		src, err := filepath.Abs(sec.File)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--secret", "id="+sec.ID+",src="+src)
+	}
+	return args, nil
+}
+
+// proxyEnvNames are the host variables docker.proxy forwards.
+var proxyEnvNames = []string{
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY",
+	"http_proxy", "https_proxy", "no_proxy", "all_proxy",
+	"GOPROXY", "GONOPROXY", "GOPRIVATE", "GONOSUMDB",
+}
+
+// dockerFileEnv reads the from_file values of docker.env on the host and
+// puts them in go-builder's environment, which the runtime CLI inherits:
+// they are passed by name (-e NAME), so they stay out of process listings.
+func dockerFileEnv(c *DockerSection, dry bool) (map[string]string, error) {
+	files := EnvMap{}
+	for k, v := range c.Env {
+		if v.FromFile != "" {
+			files[k] = v
+		}
+	}
+	vals, err := files.resolve(dry)
+	if err != nil {
+		return nil, fmt.Errorf("docker.%w", err)
+	}
+	if !dry {
+		for k, v := range vals {
+			os.Setenv(k, v)
+		}
+	}
+	return vals, nil
+}
+
+// proxyEnvArgs forwards the host's proxy settings by name, so credentials
+// in proxy URLs stay off the command line. Values set in env / docker.env
+// (or GOPROXY from proxy.use) win; nothing is forwarded with network none.
+func proxyEnvArgs(cfg *Config) []string {
+	c := cfg.Docker
+	if (c.Proxy != nil && !*c.Proxy) || c.Network == "none" {
+		return nil
+	}
+	set := mergeEnvLayers(nil, cfg.Env.literals(), c.Env.literals())
+	var args []string
+	for _, name := range proxyEnvNames {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		if _, ok := set[name]; ok || (name == "GOPROXY" && proxyEnabled(cfg)) {
+			continue
+		}
+		if c.Network != "host" && loopbackProxy(name, v) {
+			fmt.Fprintf(textOut, "note: %s points at the host's loopback, which the container cannot reach (use docker.network: host or the host's address)\n", name)
+		}
+		args = append(args, "-e", name)
+	}
+	return args
+}
+
+// loopbackProxy reports whether a proxy URL (or a GOPROXY list) names
+// localhost. Host lists such as NO_PROXY are not URLs and never match.
+func loopbackProxy(name, v string) bool {
+	if !strings.HasSuffix(strings.ToUpper(name), "PROXY") || strings.Contains(strings.ToUpper(name), "NO") {
+		return false
+	}
+	for _, p := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '|' }) {
+		u, err := url.Parse(p)
+		if err != nil {
+			continue
+		}
+		h := u.Hostname()
+		if ip := net.ParseIP(h); h == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return true
+		}
+	}
+	return false
+}
+
+// caCertContainerDir holds docker.ca_certs; update-ca-certificates reads
+// it on Debian and Alpine.
+const caCertContainerDir = "/usr/local/share/ca-certificates/go-builder"
+
+// caCertArgs mounts each CA certificate as <name>.crt and points Go at it
+// in addition to the image's own roots.
+func caCertArgs(certs []string, rt containerRuntime, label string) ([]string, error) {
+	opts := "ro"
+	if label != "" {
+		opts += "," + label
+	}
+	var args []string
+	for i, cert := range certs {
+		abs, err := filepath.Abs(cert)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return nil, fmt.Errorf("docker.ca_certs: %w", err)
+		}
+		src, err := hostMountPath(abs, rt.Bin())
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%d-%s.crt", i, strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs)))
+		args = append(args, "-v", src+":"+caCertContainerDir+"/"+name+":"+opts)
+	}
+	return append(args, "-e", "SSL_CERT_DIR="+caCertContainerDir+":/etc/ssl/certs"), nil
+}
+
+// sshAgentContainerSock is where docker.ssh_agent mounts the agent socket.
+const sshAgentContainerSock = "/run/ssh-agent.sock"
+
+// sshAgentSocket is the agent socket to mount. Docker Desktop on macOS
+// can't bind-mount host sockets and exposes the agent at a fixed path in
+// its VM instead.
+func sshAgentSocket(rt containerRuntime, dry bool) (string, error) {
+	if runtime.GOOS == "darwin" && rt.Name() == "docker" {
+		return "/run/host-services/ssh-auth.sock", nil
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		if dry {
+			return "<$SSH_AUTH_SOCK>", nil
+		}
+		return "", fmt.Errorf("docker.ssh_agent: SSH_AUTH_SOCK is not set; start ssh-agent and ssh-add your key")
+	}
+	return hostMountPath(sock, rt.Bin())
+}
+
+// imageRegistry is the registry host of an image reference.
+func imageRegistry(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// registryLogin applies docker.auth before anything is pulled: config
+// points every runtime CLI at an existing docker config, username logs
+// in with the password on stdin (stored like a manual `docker login`).
+func registryLogin(c *DockerSection, rt containerRuntime, dry bool) error {
+	a := c.Auth
+	if a.Config != "" {
+		dir, err := filepath.Abs(a.Config)
+		if err != nil {
+			return err
+		}
+		if filepath.Base(dir) == "config.json" {
+			dir = filepath.Dir(dir)
+		}
+		os.Setenv("DOCKER_CONFIG", dir)                                    // docker, nerdctl
+		os.Setenv("REGISTRY_AUTH_FILE", filepath.Join(dir, "config.json")) // podman
+	}
+	if a.Username == "" {
+		return nil
+	}
+	registry := firstNonEmpty(a.Registry, imageRegistry(dockerImage(c)))
+	args := []string{"login", "--username", a.Username, "--password-stdin", registry}
 	if dry {
-		fmt.Printf("\n# Dry-run: docker %s\n", strings.Join(runArgs, " "))
+		fmt.Fprintf(textOut, "\n# Dry-run: %s %s  # password on stdin\n", rt.Bin(), strings.Join(args, " "))
 		return nil
 	}
-	cmd := exec.Command("docker", runArgs...)
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	return cmd.Run()
+	if a.Password == "" {
+		return fmt.Errorf("docker.auth: empty password for %s (e.g. password: ${REGISTRY_TOKEN})", registry)
+	}
+	cmd := exec.Command(rt.Bin(), args...)
+	cmd.Stdin = strings.NewReader(a.Password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("docker.auth: login to %s: %s", registry, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// platformArgs is --platform for docker.platform (os/arch[/variant]).
+func platformArgs(c *DockerSection) ([]string, error) {
+	if c.Platform == "" {
+		return nil, nil
+	}
+	if n := strings.Count(c.Platform, "/"); n < 1 || n > 2 || strings.Contains(c.Platform, "//") {
+		return nil, fmt.Errorf("docker.platform: want os/arch[/variant], got %q", c.Platform)
+	}
+	return []string{"--platform", c.Platform}, nil
+}
+
+// secretTempDir is a memory-backed directory for staged secrets, so they
+// never reach a disk: $XDG_RUNTIME_DIR or /dev/shm, else the temp dir.
+func secretTempDir() string {
+	for _, dir := range []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm"} {
+		if fi, err := os.Stat(dir); dir != "" && err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// secretValues are the values of docker.secrets, to be redacted from the
+// container's output. Very short values are skipped: replacing them would
+// mangle unrelated text.
+func secretValues(secrets []Secret) []string {
+	var vals []string
+	for _, sec := range secrets {
+		v := os.Getenv(sec.Env)
+		if sec.File != "" {
+			if fi, err := os.Stat(sec.File); err == nil && fi.Size() <= 64<<10 {
+				b, _ := os.ReadFile(sec.File)
+				v = string(b)
+			}
+		}
+		for _, line := range strings.Split(v, "\n") { // e.g. netrc: one credential per line
+			if line = strings.TrimSpace(line); len(line) >= 6 {
+				vals = append(vals, line)
+			}
+		}
+	}
+	return vals
+}
+
+// redactWriter replaces secrets in whole lines before passing them on.
+type redactWriter struct {
+	w       io.Writer
+	secrets []string
+	buf     []byte
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	if i := bytes.LastIndexByte(r.buf, '\n'); i >= 0 {
+		if _, err := io.WriteString(r.w, r.redact(string(r.buf[:i+1]))); err != nil {
+			return 0, err
+		}
+		r.buf = r.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes a trailing partial line.
+func (r *redactWriter) Flush() {
+	if len(r.buf) > 0 {
+		io.WriteString(r.w, r.redact(string(r.buf)))
+		r.buf = nil
+	}
+}
+
+func (r *redactWriter) redact(s string) string {
+	for _, sec := range r.secrets {
+		s = strings.ReplaceAll(s, sec, "***")
+	}
+	return s
+}
+
+// dockerImage is docker.image or the official golang image.
+func dockerImage(c *DockerSection) string {
+	return firstNonEmpty(c.Image, "docker.io/golang:latest")
+}
+
+// imageDigest resolves the builder image to repo@sha256:…, pulling it
+// first unless docker.pull is never. An image pinned by digest must
+// match the local copy exactly.
+func imageDigest(rt containerRuntime, c *DockerSection) (string, error) {
+	image := dockerImage(c)
+	inspect := func() ([]string, error) {
+		out, err := exec.Command(rt.Bin(), "image", "inspect", "--format", "{{json .RepoDigests}}", image).Output()
+		if err != nil {
+			return nil, err
+		}
+		var digests []string
+		return digests, json.Unmarshal(out, &digests)
+	}
+	pull := func() error {
+		pa, err := platformArgs(c)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(rt.Bin(), append(append([]string{"pull"}, pa...), image)...)
+		cmd.Stdout, cmd.Stderr = textOut, os.Stderr
+		return cmd.Run()
+	}
+
+	if c.Pull == "always" {
+		if err := pull(); err != nil {
+			return "", fmt.Errorf("pull %s: %w", image, err)
+		}
+	}
+	digests, err := inspect()
+	if err != nil && c.Pull != "never" && c.Pull != "always" {
+		if err := pull(); err != nil {
+			return "", fmt.Errorf("pull %s: %w", image, err)
+		}
+		digests, err = inspect()
+	}
+	if err != nil {
+		return "", fmt.Errorf("image %s is not available locally", image)
+	}
+
+	if _, want, ok := strings.Cut(image, "@"); ok {
+		for _, d := range digests {
+			if strings.HasSuffix(d, "@"+want) {
+				return d, nil
+			}
+		}
+		return "", fmt.Errorf("local image %s does not match pinned digest %s (have %v)", image, want, digests)
+	}
+	if len(digests) == 0 {
+		return "", fmt.Errorf("image %s has no repo digest (built locally?), cannot verify it", image)
+	}
+	return digests[0], nil
+}
+
+// hostMountPath turns dir into a bind-mount source the runtime CLI accepts.
+//   - Windows:          C:\src\app     → C:/src/app
+//   - WSL + docker.exe: /mnt/c/src/app → C:/src/app
+//   - WSL + docker.exe: /home/me/app   → //wsl$/<distro>/home/me/app
+//
+// Everywhere else dir is returned unchanged.
+func hostMountPath(dir, bin string) (string, error) {
+	if runtime.GOOS == "windows" {
+		if strings.HasPrefix(dir, `\\`) {
+			return "", fmt.Errorf("cannot bind-mount UNC path %s; build from a local drive", dir)
+		}
+		return filepath.ToSlash(dir), nil
+	}
+	if !isWSL() || !windowsCLI(bin) {
+		return dir, nil
+	}
+	if rest, ok := strings.CutPrefix(dir, "/mnt/"); ok && len(rest) >= 1 && (len(rest) == 1 || rest[1] == '/') {
+		return strings.ToUpper(rest[:1]) + ":" + "/" + strings.TrimPrefix(rest[1:], "/"), nil
+	}
+	distro := os.Getenv("WSL_DISTRO_NAME")
+	if distro == "" {
+		return "", fmt.Errorf("cannot map %s for docker.exe: WSL_DISTRO_NAME is not set", dir)
+	}
+	return "//wsl$/" + distro + dir, nil
+}
+
+// isWSL reports whether we run inside Windows Subsystem for Linux.
+func isWSL() bool {
+	b, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	r := strings.ToLower(string(b))
+	return strings.Contains(r, "microsoft") || strings.Contains(r, "wsl")
+}
+
+// windowsCLI reports whether bin resolves to a Windows .exe (e.g.
+// docker.exe) through WSL interop, which expects Windows paths.
+func windowsCLI(bin string) bool {
+	p, err := exec.LookPath(bin)
+	if err != nil {
+		return false
+	}
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	}
+	return strings.HasSuffix(strings.ToLower(p), ".exe")
+}
+
+// selinuxLabel returns the volume relabel option. In auto mode the shared
+// "z" label is used whenever SELinux is enforcing, so the container may
+// read and write the bind-mounted source tree.
+func selinuxLabel(mode string) (string, error) {
+	switch mode {
+	case "", "auto":
+		if selinuxEnforcing() {
+			return "z", nil
+		}
+		return "", nil
+	case "z", "Z":
+		return mode, nil
+	case "none":
+		return "", nil
+	}
+	return "", fmt.Errorf("docker.selinux: want auto | z | Z | none, got %q", mode)
+}
+
+func selinuxEnforcing() bool {
+	b, err := os.ReadFile("/sys/fs/selinux/enforce")
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// userNamespace returns the --userns value. In auto mode the runtime
+// decides: rootless podman gets keep-id so files written to the mount
+// stay owned by the invoking user; rootless docker needs nothing.
+func userNamespace(mode string, rt containerRuntime) string {
+	switch mode {
+	case "", "auto":
+		return rt.DefaultUserNS()
+	case "none":
+		return ""
+	}
+	return mode
+}
+
+// containerUser returns the --user value for docker.user. With host (the
+// default) files written to the bind mount belong to you rather than root;
+// it's only needed on Linux with a rootful engine, as Docker Desktop,
+// rootless engines and podman (keep-id) already map container root to you.
+func containerUser(mode string, rt containerRuntime) (string, error) {
+	switch mode {
+	case "", "host":
+		if runtime.GOOS != "linux" || os.Getuid() == 0 || rt.Name() == "podman" || rootlessEngine(rt) {
+			return "", nil
+		}
+		return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()), nil
+	case "image":
+		return "", nil
+	}
+	uid, gid, _ := strings.Cut(mode, ":")
+	for _, id := range []string{uid, gid} {
+		if _, err := strconv.Atoi(firstNonEmpty(id, "0")); err != nil {
+			return "", fmt.Errorf("docker.user: want host | image | uid[:gid], got %q", mode)
+		}
+	}
+	return mode, nil
+}
+
+// rootlessEngine reports whether the docker or nerdctl daemon runs
+// rootless.
+func rootlessEngine(rt containerRuntime) bool {
+	out, err := exec.Command(rt.Bin(), "info", "--format", "{{json .SecurityOptions}}").Output()
+	return err == nil && strings.Contains(string(out), "rootless")
+}
+
+// ownVolume hands a cache volume, created root-owned, to user so builds
+// running as that user can write to it. Caches filled by earlier root
+// runs are chowned recursively, once.
+func ownVolume(rt containerRuntime, image string, platform []string, volume, user string) error {
+	format := "%u:%g"
+	if !strings.Contains(user, ":") {
+		format = "%u"
+	}
+	script := fmt.Sprintf(`[ "$(stat -c %s /c)" = %s ] || chown -R %s /c`, format, user, user)
+	args := append([]string{"run", "--rm", "--user", "0:0", "-v", volume + ":/c"}, platform...)
+	args = append(args, "--entrypoint", "sh", image, "-c", script)
+	out, err := exec.Command(rt.Bin(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("chown %s: %v: %s", volume, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell command line.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
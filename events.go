@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   --json: build events as NDJSON on stdout
+   ------------------------------------------------------------------ */
+
+// event is one NDJSON line. Human-readable output and tool output go to
+// stderr while events are enabled.
+type event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"` // start | command | result | artifact | finish
+	Target     string    `json:"target,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Result     string    `json:"result,omitempty"` // ok | error
+	Error      string    `json:"error,omitempty"`
+	Artifact   string    `json:"artifact,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+}
+
+var (
+	eventMu  sync.Mutex
+	eventOut io.Writer     // where events go
+	eventEnc *json.Encoder // nil unless --json
+)
+
+// textOut receives the human-readable output: stdout, or stderr while
+// events are enabled so stdout stays valid NDJSON.
+var textOut io.Writer = os.Stdout
+
+// enableEvents writes events to w and the human-readable output to
+// stderr.
+func enableEvents(w io.Writer) {
+	eventOut, eventEnc = w, json.NewEncoder(w)
+	textOut = os.Stderr
+}
+
+func emit(e event) {
+	if eventEnc == nil {
+		return
+	}
+	e.Time = time.Now().UTC()
+	eventMu.Lock()
+	eventEnc.Encode(e)
+	eventMu.Unlock()
+}
+
+// resultEvent reports the outcome of a step that started at start.
+func resultEvent(target string, start time.Time, err error) event {
+	e := event{Event: "result", Target: target, DurationMS: time.Since(start).Milliseconds(), Result: "ok"}
+	if err != nil {
+		e.Result, e.Error = "error", err.Error()
+	}
+	return e
+}
+
+// eventRelay passes on the events of a go-builder run in a container,
+// whose stdout it receives. Lines that aren't events (docker.setup
+// output) go to text. The finish event is dropped: the outer run reports
+// the overall result.
+type eventRelay struct {
+	text io.Writer
+	buf  []byte
+}
+
+func (r *eventRelay) Write(b []byte) (int, error) {
+	r.buf = append(r.buf, b...)
+	for {
+		i := bytes.IndexByte(r.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		r.line(r.buf[:i+1])
+		r.buf = r.buf[i+1:]
+	}
+}
+
+// Flush passes on a trailing partial line.
+func (r *eventRelay) Flush() {
+	if len(r.buf) > 0 {
+		r.line(append(r.buf, '\n'))
+		r.buf = nil
+	}
+}
+
+func (r *eventRelay) line(l []byte) {
+	var e event
+	if json.Unmarshal(l, &e) != nil || e.Event == "" {
+		r.text.Write(l)
+		return
+	}
+	if e.Event == "finish" {
+		return
+	}
+	eventMu.Lock()
+	eventOut.Write(l)
+	eventMu.Unlock()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* ------------------------------------------------------------------
+   Packages: Flatpak manifests for linux targets, and bundles built
+   with flatpak-builder
+   ------------------------------------------------------------------ */
+
+// flatpakArch is the Flatpak spelling of a GOARCH.
+var flatpakArch = map[string]string{"amd64": "x86_64", "arm64": "aarch64"}
+
+// flatpakManifest is the subset of the flatpak-builder manifest
+// go-builder writes: one module installing the prebuilt binary.
+type flatpakManifest struct {
+	AppID          string          `yaml:"app-id"`
+	Runtime        string          `yaml:"runtime"`
+	RuntimeVersion string          `yaml:"runtime-version"`
+	SDK            string          `yaml:"sdk"`
+	Command        string          `yaml:"command"`
+	FinishArgs     []string        `yaml:"finish-args,omitempty"`
+	Modules        []flatpakModule `yaml:"modules"`
+}
+
+type flatpakModule struct {
+	Name          string          `yaml:"name"`
+	BuildSystem   string          `yaml:"buildsystem"`
+	BuildCommands []string        `yaml:"build-commands"`
+	Sources       []flatpakSource `yaml:"sources"`
+}
+
+type flatpakSource struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+// defaultFinishArgs give a GUI application a display and a GPU.
+var defaultFinishArgs = []string{"--socket=wayland", "--socket=fallback-x11", "--share=ipc", "--device=dri"}
+
+// flatpakDir is the manifest directory of job j, next to its binary.
+func flatpakDir(j buildJob) string {
+	return filepath.Join(filepath.Dir(j.Out), "flatpak-"+binaryName(j.Cfg))
+}
+
+// metainfo is a minimal AppStream file carrying the release version.
+func metainfo(cfg *Config, id, name string) string {
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	p := cfg.Packages
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<component type=\"desktop-application\">\n")
+	fmt.Fprintf(&b, "  <id>%s</id>\n  <name>%s</name>\n  <summary>%s</summary>\n", esc(id), esc(name), esc(firstNonEmpty(p.Description, name)))
+	b.WriteString("  <metadata_license>CC0-1.0</metadata_license>\n")
+	if p.License != "" {
+		fmt.Fprintf(&b, "  <project_license>%s</project_license>\n", esc(p.License))
+	}
+	if p.Homepage != "" {
+		fmt.Fprintf(&b, "  <url type=\"homepage\">%s</url>\n", esc(p.Homepage))
+	}
+	fmt.Fprintf(&b, "  <launchable type=\"desktop-id\">%s.desktop</launchable>\n", esc(id))
+	fmt.Fprintf(&b, "  <releases>\n    <release version=\"%s\" date=\"%s\"/>\n  </releases>\n</component>\n",
+		esc(packageVersion(cfg)), currentMeta().BuildDate[:10])
+	return b.String()
+}
+
+// writeFlatpak writes the manifest of job j and the files it installs
+// into flatpakDir, so the directory can also be built elsewhere. It
+// returns the manifest path.
+func writeFlatpak(cfg *Config, j buildJob) (string, error) {
+	f := cfg.Packages.Flatpak
+	id, bin := f.AppID, binaryName(j.Cfg)
+	dir := flatpakDir(j)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	put := func(name string, data []byte, src string, mode os.FileMode) error {
+		if src != "" {
+			b, err := os.ReadFile(src)
+			if err != nil {
+				return fmt.Errorf("packages.flatpak: %w", err)
+			}
+			data = b
+		}
+		return os.WriteFile(filepath.Join(dir, name), data, mode)
+	}
+	m := flatpakManifest{
+		AppID: id, Runtime: firstNonEmpty(f.Runtime, "org.freedesktop.Platform"),
+		RuntimeVersion: firstNonEmpty(f.RuntimeVersion, "23.08"), SDK: firstNonEmpty(f.SDK, "org.freedesktop.Sdk"),
+		Command: bin, FinishArgs: f.FinishArgs,
+	}
+	if len(m.FinishArgs) == 0 {
+		m.FinishArgs = defaultFinishArgs
+	}
+	mod := flatpakModule{Name: bin, BuildSystem: "simple"}
+	install := func(file, mode, dst string) {
+		mod.BuildCommands = append(mod.BuildCommands, fmt.Sprintf("install -Dm%s %s %s", mode, file, dst))
+		mod.Sources = append(mod.Sources, flatpakSource{Type: "file", Path: file})
+	}
+
+	if err := put(bin, nil, j.Out, 0o755); err != nil {
+		return "", err
+	}
+	install(bin, "755", "/app/bin/"+bin)
+	name := firstNonEmpty(f.Name, packageName(cfg, j))
+	desktop := []byte(desktopEntry(name, bin, id, cfg.Packages.Description, f.Categories, false))
+	if err := put(id+".desktop", desktop, f.Desktop, 0o644); err != nil {
+		return "", err
+	}
+	install(id+".desktop", "644", "/app/share/applications/"+id+".desktop")
+	if f.Icon != "" {
+		ext := filepath.Ext(f.Icon)
+		if err := put(id+ext, nil, f.Icon, 0o644); err != nil {
+			return "", err
+		}
+		size := "256x256"
+		if ext == ".svg" {
+			size = "scalable"
+		}
+		install(id+ext, "644", "/app/share/icons/hicolor/"+size+"/apps/"+id+ext)
+	}
+	if err := put(id+".metainfo.xml", []byte(metainfo(cfg, id, name)), f.Metainfo, 0o644); err != nil {
+		return "", err
+	}
+	install(id+".metainfo.xml", "644", "/app/share/metainfo/"+id+".metainfo.xml")
+	m.Modules = []flatpakModule{mod}
+
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	manifest := filepath.Join(dir, id+".yml")
+	return manifest, os.WriteFile(manifest, b, 0o644)
+}
+
+// buildFlatpaks writes a Flatpak manifest for every amd64 and arm64 linux
+// target and, with packages.flatpak.bundle, builds it into a .flatpak
+// bundle next to the binary.
+func buildFlatpaks(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	f := cfg.Packages.Flatpak
+	if f == nil {
+		return nil
+	}
+	if f.AppID == "" || strings.Count(f.AppID, ".") < 2 {
+		return fmt.Errorf("packages.flatpak.app_id: want a reverse-DNS id such as com.example.MyApp, got %q", f.AppID)
+	}
+	var todo []buildJob
+	for _, j := range packageJobs(jobs, "linux") {
+		if flatpakArch[j.Target.Arch] == "" {
+			fmt.Fprintf(textOut, "⚠ %s: no Flatpak for %s\n", j.label(), j.Target.Arch)
+			continue
+		}
+		todo = append(todo, j)
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: Flatpak")
+		for _, j := range todo {
+			manifest := filepath.Join(flatpakDir(j), f.AppID+".yml")
+			fmt.Fprintf(textOut, "# manifest → %s\n", manifest)
+			if f.Bundle {
+				arch := flatpakArch[j.Target.Arch]
+				fmt.Fprintf(textOut, "flatpak-builder --force-clean --arch=%s --repo=<repo> <build> %s\n", arch, manifest)
+				fmt.Fprintf(textOut, "flatpak build-bundle --arch=%s <repo> %s %s\n", arch, packageFile(cfg, j, j.Target.Arch, "flatpak"), f.AppID)
+			}
+		}
+		return nil
+	}
+	if f.Bundle {
+		for _, tool := range []string{"flatpak-builder", "flatpak"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				return fmt.Errorf("packages.flatpak.bundle: %s not found in PATH", tool)
+			}
+		}
+	}
+
+	fmt.Fprintln(textOut, ">>> Flatpak")
+	for _, j := range todo {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		manifest, err := writeFlatpak(cfg, j)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(textOut, "✔ flatpak manifest %s\n", manifest)
+		if f.Bundle {
+			if err := flatpakBundle(cfg, j, manifest, m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flatpakBundle builds manifest into a temporary repository and exports
+// the app as a single-file bundle.
+func flatpakBundle(cfg *Config, j buildJob, manifest string, m *Manifest) error {
+	tmp, err := os.MkdirTemp("", "go-builder-flatpak-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	arch := flatpakArch[j.Target.Arch]
+	repo, build := filepath.Join(tmp, "repo"), filepath.Join(tmp, "build")
+	out := packageFile(cfg, j, j.Target.Arch, "flatpak")
+	for _, args := range [][]string{
+		{"flatpak-builder", "--force-clean", "--disable-rofiles-fuse", "--state-dir=" + filepath.Join(tmp, "state"),
+			"--arch=" + arch, "--repo=" + repo, build, manifest},
+		{"flatpak", "build-bundle", "--arch=" + arch, repo, out, cfg.Packages.Flatpak.AppID},
+	} {
+		if b, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %s: %v: %s", args[0], j.label(), err, strings.TrimSpace(string(b)))
+		}
+	}
+	return recordPackage(m, j, "flatpak", out)
+}
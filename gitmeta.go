@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Build metadata for build.vars: {{.GitCommit}}, {{.BuildDate}} …
+   ------------------------------------------------------------------ */
+
+// buildMeta holds the pseudo-variables usable in build.vars values.
+type buildMeta struct {
+	GitCommit string // full commit hash
+	GitTag    string // most recent tag reachable from HEAD
+	GitDirty  string // "true" when the work tree has uncommitted changes
+	BuildDate string // RFC 3339 UTC; honours SOURCE_DATE_EPOCH
+}
+
+// currentMeta is computed once, on first use.
+var currentMeta = sync.OnceValue(func() buildMeta {
+	date := sourceDate()
+	dirty := "false"
+	if git("status", "--porcelain") != "" {
+		dirty = "true"
+	}
+	return buildMeta{
+		GitCommit: git("rev-parse", "HEAD"),
+		GitTag:    git("describe", "--tags", "--abbrev=0"),
+		GitDirty:  dirty,
+		BuildDate: date.Format(time.RFC3339),
+	}
+})
+
+// sourceDate is SOURCE_DATE_EPOCH when set, otherwise the current time.
+func sourceDate() time.Time {
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(n, 0).UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+// setSourceDateEpoch exports SOURCE_DATE_EPOCH as the last commit time
+// unless the caller already set it.
+func setSourceDateEpoch() {
+	if os.Getenv("SOURCE_DATE_EPOCH") != "" {
+		return
+	}
+	if ct := git("log", "-1", "--format=%ct"); ct != "" {
+		os.Setenv("SOURCE_DATE_EPOCH", ct)
+	}
+}
+
+// git returns the trimmed output of a git command, "" on any error
+// (no git, not a repository, no tags …).
+func git(args ...string) string {
+	// the work tree may be owned by another uid inside a container
+	out, err := exec.Command("git", append([]string{"-c", "safe.directory=*"}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// renderVars expands templates in the global and per-target vars.
+func (cfg *Config) renderVars() error {
+	if err := renderMeta(cfg.Build.Vars); err != nil {
+		return err
+	}
+	for _, t := range cfg.Targets {
+		if err := renderMeta(t.Vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderMeta(vars map[string]string) error {
+	for k, v := range vars {
+		if !isTemplate(v) {
+			continue
+		}
+		tp, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		if err := tp.Execute(&b, currentMeta()); err != nil {
+			return err
+		}
+		vars[k] = b.String()
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+/* ------------------------------------------------------------------
+   Homebrew formula, generated from the manifest and pushed to a tap
+   ------------------------------------------------------------------ */
+
+// brewURLVars are the fields available in homebrew.url.
+type brewURLVars struct {
+	Name, Version, Tag, OS, Arch, File string
+}
+
+// brewBottle is one download of the formula: the binary of one platform.
+type brewBottle struct {
+	OS, CPU     string // on_macos | on_linux, on_arm | on_intel
+	URL, SHA256 string
+}
+
+// brewClass is the formula class name Homebrew derives from name:
+// my-app → MyApp, foo@2 → FooAT2.
+func brewClass(name string) string {
+	name = strings.ReplaceAll(name, "@", "AT")
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// rubyQuote is s as a Ruby double-quoted string without interpolation.
+func rubyQuote(s string) string {
+	return strings.ReplaceAll(fmt.Sprintf("%q", s), "#{", `\#{`)
+}
+
+// brewCPU is the Homebrew block of a GOARCH; other architectures have
+// no bottle.
+var brewCPU = map[string]string{"amd64": "on_intel", "arm64": "on_arm"}
+
+// brewBottles collects the darwin and linux downloads of binary from the
+// manifest: one per OS and CPU, preferring the target without a variant.
+func brewBottles(cfg *Config, jobs []buildJob, m *Manifest, binary string) ([]brewBottle, error) {
+	h := cfg.Homebrew
+	tmpl, err := template.New("homebrew.url").Option("missingkey=error").Parse(h.URL)
+	if err != nil {
+		return nil, fmt.Errorf("homebrew.url: %w", err)
+	}
+	version := releaseVersion(cfg)
+	var bottles []brewBottle
+	var variant []bool
+	for _, j := range jobs {
+		cpu := brewCPU[j.Target.Arch]
+		if binaryName(j.Cfg) != binary || (j.Target.OS != "darwin" && j.Target.OS != "linux") || cpu == "" {
+			continue
+		}
+		a := findArtifact(m, j.Out)
+		if a == nil {
+			continue // not built (--keep-going)
+		}
+		var url strings.Builder
+		vars := brewURLVars{Name: binary, Version: version, Tag: firstNonEmpty(cfg.Version, currentMeta().GitTag, "v"+version),
+			OS: j.Target.OS, Arch: j.Target.Arch, File: filepath.Base(j.Out)}
+		if err := tmpl.Execute(&url, vars); err != nil {
+			return nil, fmt.Errorf("homebrew.url: %w", err)
+		}
+		b := brewBottle{OS: "on_" + map[string]string{"darwin": "macos", "linux": "linux"}[j.Target.OS], CPU: cpu,
+			URL: url.String(), SHA256: a.SHA256}
+		i := slices.IndexFunc(bottles, func(o brewBottle) bool { return o.OS == b.OS && o.CPU == b.CPU })
+		switch {
+		case i < 0:
+			bottles = append(bottles, b)
+			variant = append(variant, j.Target.Variant != "")
+		case variant[i] && j.Target.Variant == "":
+			bottles[i], variant[i] = b, false
+		}
+	}
+	if len(bottles) == 0 {
+		return nil, fmt.Errorf("homebrew: no darwin or linux amd64/arm64 build of %s in the manifest", binary)
+	}
+	// on_macos before on_linux
+	slices.SortFunc(bottles, func(a, b brewBottle) int { return cmp.Or(strings.Compare(b.OS, a.OS), strings.Compare(a.CPU, b.CPU)) })
+	return bottles, nil
+}
+
+// brewFormula renders the formula. Each platform downloads the bare
+// binary, which install renames to the binary name.
+func brewFormula(cfg *Config, name, binary string, bottles []brewBottle) string {
+	h := cfg.Homebrew
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by go-builder; do not edit.\nclass %s < Formula\n", brewClass(name))
+	if h.Description != "" {
+		fmt.Fprintf(&b, "  desc %s\n", rubyQuote(h.Description))
+	}
+	if h.Homepage != "" {
+		fmt.Fprintf(&b, "  homepage %s\n", rubyQuote(h.Homepage))
+	}
+	fmt.Fprintf(&b, "  version %s\n", rubyQuote(releaseVersion(cfg)))
+	if h.License != "" {
+		fmt.Fprintf(&b, "  license %s\n", rubyQuote(h.License))
+	}
+	for i, bt := range bottles {
+		if i == 0 || bottles[i-1].OS != bt.OS {
+			fmt.Fprintf(&b, "\n  %s do\n", bt.OS)
+		}
+		fmt.Fprintf(&b, "    %s do\n      url %s\n      sha256 %s\n    end\n", bt.CPU, rubyQuote(bt.URL), rubyQuote(bt.SHA256))
+		if i == len(bottles)-1 || bottles[i+1].OS != bt.OS {
+			b.WriteString("  end\n")
+		}
+	}
+	fmt.Fprintf(&b, "\n  def install\n    bin.install File.basename(stable.url) => %s\n  end\n", rubyQuote(binary))
+	if len(h.Test) > 0 {
+		args := []string{"bin/" + rubyQuote(binary)}
+		for _, a := range h.Test {
+			args = append(args, rubyQuote(a))
+		}
+		fmt.Fprintf(&b, "\n  test do\n    system %s\n  end\n", strings.Join(args, ", "))
+	}
+	b.WriteString("end\n")
+	return b.String()
+}
+
+// writeHomebrew writes <name>.rb to the build directory and, with
+// homebrew.tap, commits it to the tap repository and pushes.
+func writeHomebrew(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	h := cfg.Homebrew
+	if h.URL == "" {
+		return fmt.Errorf("homebrew.url is required (https://github.com/me/app/releases/download/{{.Tag}}/{{.File}})")
+	}
+	binary, err := pickBinary(jobs, "homebrew.binary", cfg.Homebrew.Binary)
+	if err != nil {
+		return err
+	}
+	name := firstNonEmpty(h.Name, binary)
+	out := filepath.Join(cfg.BuildDir, name+".rb")
+	dir := firstNonEmpty(h.Directory, "Formula")
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: homebrew formula → %s\n", out)
+		if h.Tap != "" {
+			fmt.Fprintf(textOut, "git clone --depth 1 %s <tap> && cp %s <tap>/%s/ && git commit && git push\n", h.Tap, out, dir)
+		}
+		return nil
+	}
+	bottles, err := brewBottles(cfg, jobs, m, binary)
+	if err != nil {
+		return err
+	}
+	formula := brewFormula(cfg, name, binary, bottles)
+	if err := os.WriteFile(out, []byte(formula), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(textOut, "✔ homebrew formula %s\n", out)
+	if h.Tap == "" {
+		return nil
+	}
+	return pushTap(h, name, dir, formula, releaseVersion(cfg))
+}
+
+// pushTap commits the formula to a shallow clone of the tap and pushes
+// it. An unchanged formula is not committed.
+func pushTap(h *HomebrewSection, name, dir, formula, version string) error {
+	tmp, err := os.MkdirTemp("", "go-builder-tap-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	clone := []string{"clone", "--quiet", "--depth", "1"}
+	if h.Branch != "" {
+		clone = append(clone, "--branch", h.Branch)
+	}
+	if err := gitRun("", append(clone, h.Tap, tmp)...); err != nil {
+		return fmt.Errorf("homebrew.tap: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, dir), 0o755); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, name+".rb")
+	if err := os.WriteFile(filepath.Join(tmp, file), []byte(formula), 0o644); err != nil {
+		return err
+	}
+	if err := gitRun(tmp, "add", file); err != nil {
+		return err
+	}
+	if exec.Command("git", "-C", tmp, "diff", "--cached", "--quiet").Run() == nil {
+		fmt.Fprintf(textOut, "✔ homebrew tap already has %s\n", file)
+		return nil
+	}
+	msg := firstNonEmpty(h.CommitMessage, fmt.Sprintf("%s %s", name, version))
+	if exec.Command("git", "-C", tmp, "config", "user.email").Run() != nil {
+		// no identity, as in a fresh builder container
+		gitRun(tmp, "config", "user.name", "go-builder")
+		gitRun(tmp, "config", "user.email", "go-builder@localhost")
+	}
+	if err := gitRun(tmp, "commit", "--quiet", "-m", msg); err != nil {
+		return err
+	}
+	if err := gitRun(tmp, "push", "--quiet", "origin", "HEAD"); err != nil {
+		return fmt.Errorf("homebrew.tap: %w", err)
+	}
+	fmt.Fprintf(textOut, "✔ pushed %s to %s\n", file, h.Tap)
+	return nil
+}
+
+// gitRun runs git in dir ("" for the current directory).
+func gitRun(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
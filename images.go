@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Runtime images: the built linux binaries on a small base image
+   ------------------------------------------------------------------ */
+
+// imageBinDir is where the binary goes in a runtime image.
+const imageBinDir = "/usr/local/bin"
+
+// defaultImageBase is the base of runtime images without images.base.
+const defaultImageBase = "gcr.io/distroless/static-debian12"
+
+// imagePlatform is the OCI platform of target t: linux/arm/v7,
+// linux/amd64/v3, linux/arm64.
+func imagePlatform(t Target) string {
+	if v := t.variantDir(); v != "" && (t.Arch == "arm" || t.Arch == "amd64") {
+		return "linux/" + t.Arch + "/" + v
+	}
+	return "linux/" + t.Arch
+}
+
+// imageJobs are the linux jobs of the binary the images are built from.
+func imageJobs(cfg *Config, jobs []buildJob) ([]buildJob, error) {
+	linux := packageJobs(jobs, "linux")
+	if len(linux) == 0 {
+		return nil, nil
+	}
+	binary, err := pickBinary(linux, "images.binary", cfg.Images.Binary)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(linux, func(j buildJob) bool { return binaryName(j.Cfg) != binary }), nil
+}
+
+// imageTags are the references of job j's image. With several targets
+// every tag gets the platform appended (1.2.3-arm64, 1.2.3-arm-v7), so
+// the per-platform images don't overwrite each other.
+func imageTags(cfg *Config, j buildJob, multi bool) []string {
+	tags := cfg.Images.Tags
+	if len(tags) == 0 {
+		tags = []string{releaseVersion(cfg)}
+	}
+	out := make([]string, len(tags))
+	for i, tag := range tags {
+		if multi {
+			tag += "-" + platformSuffix(j.Target)
+		}
+		out[i] = cfg.Images.Repository + ":" + tag
+	}
+	return out
+}
+
+// platformSuffix names the platform of t in tags and file names: amd64,
+// arm-v7.
+func platformSuffix(t Target) string {
+	return strings.ReplaceAll(strings.TrimPrefix(imagePlatform(t), "linux/"), "/", "-")
+}
+
+// imageLabels are the OCI annotations go-builder knows, overridden by
+// images.labels.
+func imageLabels(cfg *Config, binary string) map[string]string {
+	meta := currentMeta()
+	l := map[string]string{
+		"org.opencontainers.image.title":   binary,
+		"org.opencontainers.image.version": releaseVersion(cfg),
+		"org.opencontainers.image.created": meta.BuildDate,
+	}
+	if meta.GitCommit != "" {
+		l["org.opencontainers.image.revision"] = meta.GitCommit
+	}
+	maps.Copy(l, cfg.Images.Labels)
+	return l
+}
+
+// jsonList is a Dockerfile exec-form list.
+func jsonList(args []string) string {
+	q := make([]string, len(args))
+	for i, a := range args {
+		q[i] = strconv.Quote(a)
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+// imageDockerfile copies the binary (as bin) and images.files (as
+// files/<i>) onto the base.
+func imageDockerfile(cfg *Config, binary string) string {
+	im := cfg.Images
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\n", firstNonEmpty(im.Base, defaultImageBase))
+	fmt.Fprintf(&b, "COPY bin %s/%s\n", imageBinDir, binary)
+	for i, src := range sortedKeys(im.Files) {
+		fmt.Fprintf(&b, "COPY files/%d %s\n", i, im.Files[src])
+	}
+	labels := imageLabels(cfg, binary)
+	for _, k := range sortedKeys(labels) {
+		fmt.Fprintf(&b, "LABEL %s=%s\n", k, strconv.Quote(labels[k]))
+	}
+	if im.User != "" {
+		fmt.Fprintf(&b, "USER %s\n", im.User)
+	}
+	entry := im.Entrypoint
+	if len(entry) == 0 {
+		entry = []string{imageBinDir + "/" + binary}
+	}
+	fmt.Fprintf(&b, "ENTRYPOINT %s\n", jsonList(entry))
+	if len(im.Cmd) > 0 {
+		fmt.Fprintf(&b, "CMD %s\n", jsonList(im.Cmd))
+	}
+	return b.String()
+}
+
+// writeImageContext lays out the build context of job j in dir.
+func writeImageContext(cfg *Config, j buildJob, binary, dir string) error {
+	copyTo := func(src, dst string, mode os.FileMode) error {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return fmt.Errorf("images: %w", err)
+		}
+		return os.WriteFile(filepath.Join(dir, dst), b, mode)
+	}
+	if err := copyTo(j.Out, "bin", 0o755); err != nil {
+		return err
+	}
+	if err := os.Mkdir(filepath.Join(dir, "files"), 0o755); err != nil {
+		return err
+	}
+	for i, src := range sortedKeys(cfg.Images.Files) {
+		if err := copyTo(src, filepath.Join("files", strconv.Itoa(i)), 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(imageDockerfile(cfg, binary)), 0o644)
+}
+
+// imageRuntime is the engine images are built with on the local build
+// path: the docker section's runtime and context, else whichever is
+// installed. images.tarball needs none.
+func imageRuntime(cfg *Config) (containerRuntime, error) {
+	if cfg.Images.Tarball != "" {
+		return nil, nil
+	}
+	if cfg.Docker == nil {
+		return selectRuntime("")
+	}
+	rt, err := selectRuntime(cfg.Docker.Runtime)
+	if err != nil {
+		return nil, err
+	}
+	return rt, useEngine(cfg, rt)
+}
+
+// hostImages builds the images after a docker build, on the host: the
+// builder container has no engine. The binaries are the ones the
+// container left in build_dir.
+func hostImages(cfg *Config, rt containerRuntime, bins []*Config, sel []string, dry bool) error {
+	var jobs []buildJob
+	for _, bc := range bins {
+		js, err := planJobs(bc, sliceToMap(os.Environ()), nil, dry)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, js...)
+	}
+	jobs, err := selectJobs(jobs, sel)
+	if err != nil {
+		return err
+	}
+	m, err := loadManifest(cfg.BuildDir)
+	if err != nil {
+		return err
+	}
+	if err := buildRuntimeImages(cfg, rt, jobs, m, dry); err != nil || dry {
+		return err
+	}
+	return m.save(cfg.BuildDir)
+}
+
+// buildRuntimeImages builds an image per linux target of the binary,
+// tagged with the version, records it in the manifest and, with
+// images.push, pushes it.
+func buildRuntimeImages(cfg *Config, rt containerRuntime, jobs []buildJob, m *Manifest, dry bool) error {
+	im := cfg.Images
+	if im.Repository == "" {
+		return fmt.Errorf("images.repository is required (e.g. ghcr.io/me/app)")
+	}
+	jobs, err := imageJobs(cfg, jobs)
+	if err != nil || len(jobs) == 0 {
+		return err
+	}
+	multi := len(jobs) > 1
+	switch im.Tarball {
+	case "":
+	case "docker", "oci":
+		if im.Push {
+			return fmt.Errorf("images.tarball: the images are only exported; push them with `docker load` and `docker push`, or drop images.push")
+		}
+		return tarballImages(cfg, jobs, multi, m, dry)
+	default:
+		return fmt.Errorf("images.tarball: want docker | oci, got %q", im.Tarball)
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: runtime images")
+		fmt.Fprint(textOut, imageDockerfile(cfg, binaryName(jobs[0].Cfg)))
+		for _, j := range jobs {
+			tags := imageTags(cfg, j, multi)
+			fmt.Fprintf(textOut, "%s build --platform %s -t %s <context>\n", rt.Bin(), imagePlatform(j.Target), strings.Join(tags, " -t "))
+			if im.Push {
+				for _, t := range tags {
+					fmt.Fprintf(textOut, "%s push %s\n", rt.Bin(), t)
+				}
+			}
+		}
+		if multi && im.Push && imageLists(im) {
+			for _, l := range imageListsFor(cfg, jobs) {
+				if err := pushImageList(rt, l.Ref, l.Sources, true); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	fmt.Fprintln(textOut, ">>> Runtime images")
+	var built []buildJob
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		if err := runtimeImage(cfg, rt, j, imageTags(cfg, j, multi), m); err != nil {
+			return err
+		}
+		built = append(built, j)
+	}
+	if !multi || !imageLists(im) {
+		return nil
+	}
+	if !im.Push {
+		fmt.Fprintln(textOut, "⚠ no multi-arch tags: the per-platform images must be pushed first (images.push)")
+		return nil
+	}
+	for _, l := range imageListsFor(cfg, built) {
+		if err := pushImageList(rt, l.Ref, l.Sources, false); err != nil {
+			return err
+		}
+		m.addImageList(l)
+		fmt.Fprintf(textOut, "✔ multi-arch %s (%d platforms)\n", l.Ref, len(l.Sources))
+	}
+	return nil
+}
+
+// tarballImages assembles the images without an engine and writes each
+// to a tarball next to its binary.
+func tarballImages(cfg *Config, jobs []buildJob, multi bool, m *Manifest, dry bool) error {
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: runtime image tarballs (no engine)")
+		for _, j := range jobs {
+			fmt.Fprintf(textOut, "# %s on %s → %s\n", imagePlatform(j.Target), firstNonEmpty(cfg.Images.Base, defaultImageBase), imageTarball(cfg, j))
+		}
+		return nil
+	}
+	fmt.Fprintln(textOut, ">>> Runtime images")
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		if err := tarballImage(cfg, j, imageTags(cfg, j, multi), m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// imageLists reports whether images.manifest is on (the default).
+func imageLists(im *ImagesSection) bool {
+	return im.Manifest == nil || *im.Manifest
+}
+
+// imageListsFor pairs every tag with the per-platform images of jobs:
+// repo:1.2.3 ← repo:1.2.3-amd64, repo:1.2.3-arm64.
+func imageListsFor(cfg *Config, jobs []buildJob) []ManifestImageList {
+	var lists []ManifestImageList
+	for i, single := range imageTags(cfg, buildJob{}, false) {
+		l := ManifestImageList{Ref: single}
+		for _, j := range jobs {
+			l.Sources = append(l.Sources, imageTags(cfg, j, true)[i])
+		}
+		lists = append(lists, l)
+	}
+	return lists
+}
+
+// imageListArgs are the commands creating and pushing the manifest list
+// ref of sources: buildx imagetools when docker has it (it works on the
+// registry directly), else docker manifest, or podman manifest.
+func imageListArgs(rt containerRuntime, ref string, sources []string) ([][]string, error) {
+	switch rt.Name() {
+	case "docker":
+		if exec.Command(rt.Bin(), "buildx", "version").Run() == nil {
+			return [][]string{append([]string{"buildx", "imagetools", "create", "-t", ref}, sources...)}, nil
+		}
+		return [][]string{
+			append([]string{"manifest", "create", "--amend", ref}, sources...),
+			{"manifest", "push", "--purge", ref},
+		}, nil
+	case "podman":
+		return [][]string{
+			append([]string{"manifest", "create", "--amend", ref}, sources...),
+			{"manifest", "push", "--all", ref, "docker://" + ref},
+		}, nil
+	}
+	return nil, fmt.Errorf("images: %s can't create multi-arch manifests; use docker or podman, or images.manifest: false", rt.Name())
+}
+
+// pushImageList creates the manifest list ref over the pushed sources
+// and pushes it.
+func pushImageList(rt containerRuntime, ref string, sources []string, dry bool) error {
+	cmds, err := imageListArgs(rt, ref, sources)
+	if err != nil {
+		return err
+	}
+	for _, args := range cmds {
+		if dry {
+			fmt.Fprintf(textOut, "%s %s\n", rt.Bin(), strings.Join(args, " "))
+			continue
+		}
+		if b, err := exec.Command(rt.Bin(), args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("multi-arch %s: %v: %s", ref, err, strings.TrimSpace(string(b)))
+		}
+	}
+	return nil
+}
+
+// runtimeImage builds, and with images.push pushes, the image of job j.
+func runtimeImage(cfg *Config, rt containerRuntime, j buildJob, tags []string, m *Manifest) error {
+	dir, err := os.MkdirTemp("", "go-builder-image-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := writeImageContext(cfg, j, binaryName(j.Cfg), dir); err != nil {
+		return err
+	}
+	platform := imagePlatform(j.Target)
+	args := []string{"build", "--platform", platform}
+	for _, t := range tags {
+		args = append(args, "-t", t)
+	}
+	cmd := exec.Command(rt.Bin(), append(args, dir)...)
+	cmd.Stdout, cmd.Stderr = textOut, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("image %s: %w", j.label(), err)
+	}
+	img := ManifestImage{Target: j.Target.label(j.Cfg), Platform: platform, Tags: tags}
+	if cfg.Images.Push {
+		for _, t := range tags {
+			if b, err := exec.Command(rt.Bin(), "push", t).CombinedOutput(); err != nil {
+				return fmt.Errorf("push %s: %v: %s", t, err, strings.TrimSpace(string(b)))
+			}
+		}
+		img.Pushed = true
+	}
+	m.addImage(img)
+	fmt.Fprintf(textOut, "✔ image %s (%s)\n", tags[0], platform)
+	return nil
+}
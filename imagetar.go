@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+/* ------------------------------------------------------------------
+   Runtime images without an engine: assembled in-process and written
+   as docker save or OCI tarballs (images.tarball)
+   ------------------------------------------------------------------ */
+
+// imageTarball is the tarball of job j's image, next to its binary.
+func imageTarball(cfg *Config, j buildJob) string {
+	return filepath.Join(filepath.Dir(j.Out), fmt.Sprintf("%s_%s_%s.%s.tar",
+		binaryName(j.Cfg), releaseVersion(cfg), platformSuffix(j.Target), cfg.Images.Tarball))
+}
+
+// imageLayer is the layer imageDockerfile's COPY steps would add: the
+// binary and images.files, with parent directories, dated by the build.
+func imageLayer(cfg *Config, j buildJob, binary string) (v1.Layer, error) {
+	type entry struct {
+		src, dst string
+		mode     int64
+	}
+	entries := []entry{{j.Out, path.Join(imageBinDir, binary), 0o755}}
+	for _, src := range sortedKeys(cfg.Images.Files) {
+		entries = append(entries, entry{src, cfg.Images.Files[src], 0o644})
+	}
+	mtime := sourceDate()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	for _, e := range entries {
+		b, err := os.ReadFile(e.src)
+		if err != nil {
+			return nil, fmt.Errorf("images: %w", err)
+		}
+		dst := strings.TrimPrefix(path.Clean("/"+e.dst), "/")
+		var parents []string
+		for d := path.Dir(dst); d != "." && !dirs[d]; d = path.Dir(d) {
+			dirs[d] = true
+			parents = append([]string{d}, parents...)
+		}
+		for _, d := range parents {
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d + "/", Mode: 0o755, ModTime: mtime}); err != nil {
+				return nil, err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: dst, Mode: e.mode, Size: int64(len(b)), ModTime: mtime}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	mt := types.DockerLayer
+	if cfg.Images.Tarball == "oci" {
+		mt = types.OCILayer
+	}
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+		tarball.WithMediaType(mt))
+}
+
+// assembleImage is job j's image: the base for its platform, fetched from
+// the registry (scratch needs nothing), plus imageLayer and the config
+// imageDockerfile sets.
+func assembleImage(cfg *Config, j buildJob) (v1.Image, error) {
+	im := cfg.Images
+	binary := binaryName(j.Cfg)
+	platform, err := v1.ParsePlatform(imagePlatform(j.Target))
+	if err != nil {
+		return nil, err
+	}
+	base := empty.Image
+	if b := firstNonEmpty(im.Base, defaultImageBase); b != "scratch" {
+		ref, err := name.ParseReference(b)
+		if err != nil {
+			return nil, fmt.Errorf("images.base: %w", err)
+		}
+		if base, err = remote.Image(ref, remote.WithPlatform(*platform), remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+			return nil, fmt.Errorf("images.base %s: %w", b, err)
+		}
+	}
+	layer, err := imageLayer(cfg, j, binary)
+	if err != nil {
+		return nil, err
+	}
+	img, err := mutate.AppendLayers(base, layer)
+	if err != nil {
+		return nil, err
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cf = cf.DeepCopy()
+	cf.OS, cf.Architecture, cf.Variant = platform.OS, platform.Architecture, platform.Variant
+	cf.Created = v1.Time{Time: sourceDate()}
+	c := &cf.Config
+	c.Entrypoint = im.Entrypoint
+	if len(c.Entrypoint) == 0 {
+		c.Entrypoint = []string{imageBinDir + "/" + binary}
+	}
+	c.Cmd = im.Cmd // as in a Dockerfile, a new ENTRYPOINT clears the base's CMD
+	if im.User != "" {
+		c.User = im.User
+	}
+	if c.Labels == nil {
+		c.Labels = map[string]string{}
+	}
+	for k, v := range imageLabels(cfg, binary) {
+		c.Labels[k] = v
+	}
+	if img, err = mutate.ConfigFile(img, cf); err != nil || im.Tarball != "oci" {
+		return img, err
+	}
+	return mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), types.OCIConfigJSON), nil
+}
+
+// writeImageTarball writes img, tagged with tags, to out: a docker save
+// archive for `docker load`, or an OCI image layout archive.
+func writeImageTarball(img v1.Image, tags []string, format, out string) error {
+	if format == "docker" {
+		refs := map[name.Reference]v1.Image{}
+		for _, t := range tags {
+			tag, err := name.NewTag(t)
+			if err != nil {
+				return fmt.Errorf("images: %w", err)
+			}
+			refs[tag] = img
+		}
+		return tarball.MultiRefWriteToFile(out, refs)
+	}
+	dir, err := os.MkdirTemp("", "go-builder-oci-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		_, tag, _ := strings.Cut(t[strings.LastIndexByte(t, '/')+1:], ":")
+		err := p.AppendImage(img, layout.WithAnnotations(map[string]string{
+			"org.opencontainers.image.ref.name": tag, // skopeo, podman
+			"io.containerd.image.name":          t,   // docker, nerdctl
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return tarDir(dir, out)
+}
+
+// tarDir archives the files of dir, relative to it, into out.
+func tarDir(dir, out string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		h, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		h.Name, h.ModTime = filepath.ToSlash(rel), sourceDate()
+		if d.IsDir() {
+			h.Name += "/"
+		}
+		if err := tw.WriteHeader(h); err != nil || d.IsDir() {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tarballImage assembles the image of job j and writes its tarball.
+func tarballImage(cfg *Config, j buildJob, tags []string, m *Manifest) error {
+	img, err := assembleImage(cfg, j)
+	if err != nil {
+		return fmt.Errorf("image %s: %w", j.label(), err)
+	}
+	out := imageTarball(cfg, j)
+	if err := writeImageTarball(img, tags, cfg.Images.Tarball, out); err != nil {
+		return fmt.Errorf("image %s: %w", j.label(), err)
+	}
+	m.addImage(ManifestImage{Target: j.Target.label(j.Cfg), Platform: imagePlatform(j.Target), Tags: tags, Tarball: out})
+	fmt.Fprintf(textOut, "✔ image %s (%s) → %s\n", tags[0], imagePlatform(j.Target), out)
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* ------------------------------------------------------------------
+   `go-builder inspect <binary>`: what is this binary?
+   ------------------------------------------------------------------ */
+
+func inspectCmd(path, buildDir string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  (%s)\n", path, humanSize(st.Size()))
+
+	bin, err := readBinary(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nformat   %s/%s\nlinkage  %s\n", bin.Format, bin.Arch, bin.linkage())
+	for _, l := range bin.Libs {
+		fmt.Printf("         %s\n", l)
+	}
+
+	if bi, err := buildinfo.ReadFile(path); err == nil {
+		fmt.Printf("\ngo       %s\npath     %s\nmodule   %s %s\n", bi.GoVersion, bi.Path, bi.Main.Path, bi.Main.Version)
+		fmt.Println("\nbuild settings:")
+		for _, s := range bi.Settings {
+			fmt.Printf("  %-16s %s\n", s.Key, s.Value)
+		}
+		if len(bi.Deps) > 0 {
+			fmt.Println("\ndependencies:")
+			for _, d := range bi.Deps {
+				v := d.Version
+				if d.Replace != nil {
+					v += " => " + d.Replace.Path + " " + d.Replace.Version
+				}
+				fmt.Printf("  %s %s\n", d.Path, v)
+			}
+		}
+	} else {
+		fmt.Println("\nno Go build info (not a Go binary, or stripped by tinygo)")
+	}
+
+	if a := manifestEntry(buildDir, path); a != nil {
+		fmt.Printf("\nmanifest %s\n  target %s\n  sha256 %s\n", filepath.Join(buildDir, manifestName), a.Target, a.SHA256)
+		if sum, _, err := fileSHA256(path); err == nil && sum != a.SHA256 {
+			fmt.Println("  WARNING: file no longer matches the manifest checksum")
+		}
+	}
+
+	fmt.Println("\nsections:")
+	for _, s := range bin.sectionsBySize() {
+		if s.Size == 0 {
+			continue
+		}
+		fmt.Printf("  %-24s %10s  %5.1f%%\n", s.Name, humanSize(int64(s.Size)), 100*float64(s.Size)/float64(st.Size()))
+	}
+	return nil
+}
+
+// manifestEntry finds path in build_dir/manifest.json, if recorded.
+func manifestEntry(buildDir, path string) *ManifestArtifact {
+	m, err := loadManifest(buildDir)
+	if err != nil {
+		return nil
+	}
+	abs, _ := filepath.Abs(path)
+	for i, a := range m.Artifacts {
+		if p, _ := filepath.Abs(filepath.FromSlash(a.Path)); p == abs {
+			return &m.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
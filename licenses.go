@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   License scan of linked modules, allow/deny policy, NOTICE file
+   ------------------------------------------------------------------ */
+
+const noticesName = "THIRD_PARTY_NOTICES"
+
+// moduleLicense is one dependency module linked into a binary.
+type moduleLicense struct {
+	Path, Version string
+	SPDX          string // "unknown" when not recognised
+	File          string // license file, "" when none was found
+}
+
+// runLicenseScan classifies the license of every module linked into the
+// given sources, fails on policy violations and optionally writes the
+// notices file into the build dir.
+func runLicenseScan(cfg *Config, sources []string, env []string, dry bool) error {
+	l := cfg.Licenses
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: license scan of %s (allow %v, deny %v)\n", strings.Join(sources, " "), l.Allow, l.Deny)
+		return nil
+	}
+	fmt.Fprintln(textOut, ">>> Licenses")
+	mods, err := linkedModules(sources, env)
+	if err != nil {
+		return err
+	}
+	var bad []string
+	for _, m := range mods {
+		if !licenseAllowed(m.SPDX, l.Allow, l.Deny) {
+			bad = append(bad, fmt.Sprintf("  %s %s: %s", m.Path, m.Version, m.SPDX))
+		}
+	}
+	if l.Notices {
+		if err := writeNotices(filepath.Join(cfg.BuildDir, noticesName), mods); err != nil {
+			return err
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("license policy violated by %d modules:\n%s", len(bad), strings.Join(bad, "\n"))
+	}
+	fmt.Fprintf(textOut, "✔ %d modules, licenses ok\n", len(mods))
+	return nil
+}
+
+func licenseAllowed(spdx string, allow, deny []string) bool {
+	for _, d := range deny {
+		if strings.EqualFold(d, spdx) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, a := range allow {
+		if strings.EqualFold(a, spdx) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedModules lists the non-main modules providing packages that the
+// sources depend on, with their classified license.
+func linkedModules(sources []string, env []string) ([]moduleLicense, error) {
+	args := append([]string{"list", "-deps", "-f", "{{with .Module}}{{if not .Main}}{{.Path}}\t{{.Version}}\t{{.Dir}}{{end}}{{end}}"}, sources...)
+	cmd := exec.Command(goBin, args...)
+	cmd.Env = env
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -deps: %w", err)
+	}
+	seen := map[string]bool{}
+	var mods []moduleLicense
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Split(sc.Text(), "\t")
+		if len(f) != 3 || seen[f[0]] {
+			continue
+		}
+		seen[f[0]] = true
+		dir := f[2]
+		if dir == "" { // -mod=vendor
+			dir = filepath.Join("vendor", filepath.FromSlash(f[0]))
+		}
+		m := moduleLicense{Path: f[0], Version: f[1], SPDX: "unknown"}
+		if file := licenseFile(dir); file != "" {
+			m.File = file
+			if b, err := os.ReadFile(file); err == nil {
+				m.SPDX = classifyLicense(string(b))
+			}
+		}
+		mods = append(mods, m)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+	return mods, nil
+}
+
+// licenseFile finds LICENSE, LICENCE, COPYING (any suffix or case) in dir.
+func licenseFile(dir string) string {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		name := strings.ToUpper(e.Name())
+		if !e.IsDir() && (strings.HasPrefix(name, "LICENSE") || strings.HasPrefix(name, "LICENCE") || strings.HasPrefix(name, "COPYING")) {
+			return filepath.Join(dir, e.Name())
+		}
+	}
+	return ""
+}
+
+var spdxTag = regexp.MustCompile(`SPDX-License-Identifier:\s*([\w.+-]+)`)
+
+// classifyLicense recognises the common open source licenses by their
+// characteristic wording; an SPDX-License-Identifier line wins.
+func classifyLicense(text string) string {
+	if m := spdxTag.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+	t := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	has := func(s string) bool { return strings.Contains(t, s) }
+	switch {
+	case has("apache license") && has("version 2.0"):
+		return "Apache-2.0"
+	case has("mozilla public license") && has("2.0"):
+		return "MPL-2.0"
+	case has("gnu affero general public license"):
+		return "AGPL-3.0"
+	case has("gnu lesser general public license") && has("version 3"):
+		return "LGPL-3.0"
+	case has("gnu lesser general public license"), has("gnu library general public license"):
+		return "LGPL-2.1"
+	case has("gnu general public license") && has("version 3"):
+		return "GPL-3.0"
+	case has("gnu general public license"):
+		return "GPL-2.0"
+	case has("permission is hereby granted, free of charge"):
+		return "MIT"
+	case has("permission to use, copy, modify, and/or distribute this software for any purpose with or without fee"):
+		if has("the above copyright notice and this permission notice appear in all copies") {
+			return "ISC"
+		}
+		return "0BSD"
+	case has("redistribution and use in source and binary forms"):
+		if has("neither the name") || has("names of its contributors") {
+			return "BSD-3-Clause"
+		}
+		return "BSD-2-Clause"
+	case has("this is free and unencumbered software released into the public domain"):
+		return "Unlicense"
+	}
+	return "unknown"
+}
+
+// writeNotices concatenates the license texts of mods.
+func writeNotices(path string, mods []moduleLicense) error {
+	var b strings.Builder
+	b.WriteString("This software includes the following third-party modules.\n")
+	for _, m := range mods {
+		fmt.Fprintf(&b, "\n================================================================\n%s %s (%s)\n\n", m.Path, m.Version, m.SPDX)
+		if m.File == "" {
+			b.WriteString("No license file found.\n")
+			continue
+		}
+		text, err := os.ReadFile(m.File)
+		if err != nil {
+			return err
+		}
+		b.Write(bytes.TrimRight(text, "\n"))
+		b.WriteString("\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
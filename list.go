@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   `go-builder list`: the resolved target matrix, without building
+   ------------------------------------------------------------------ */
+
+// listedTarget is one row of `list` output.
+type listedTarget struct {
+	Binary       string            `json:"binary,omitempty"`
+	OS           string            `json:"os"`
+	Arch         string            `json:"arch"`
+	Output       string            `json:"output"`
+	VerifyStatic bool              `json:"verify_static"`
+	Env          map[string]string `json:"env"` // differences from the current environment
+}
+
+func listCmd(cfg *Config, bins []*Config, patterns []string, asJSON bool) error {
+	base := sliceToMap(os.Environ())
+	global, err := cfg.Env.resolve(true) // never read from_file secrets here
+	if err != nil {
+		return err
+	}
+	var jobs []buildJob
+	for _, bc := range bins {
+		js, err := planJobs(bc, base, global, true)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, js...)
+	}
+	if jobs, err = selectJobs(jobs, patterns); err != nil {
+		return err
+	}
+
+	rows := make([]listedTarget, 0, len(jobs))
+	for _, j := range jobs {
+		rows = append(rows, listedTarget{
+			Binary:       j.Cfg.binary,
+			OS:           j.Target.OS,
+			Arch:         j.Target.Arch,
+			Output:       j.Out,
+			VerifyStatic: j.WantStatic,
+			Env:          diffEnv(base, j.Env),
+		})
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	fmt.Printf("%-28s %-44s %-7s %s\n", "target", "output", "static", "env")
+	for i, r := range rows {
+		var env []string
+		for _, k := range sortedKeys(r.Env) {
+			env = append(env, k+"="+r.Env[k])
+		}
+		static := "no"
+		if r.VerifyStatic {
+			static = "yes"
+		}
+		fmt.Printf("%-28s %-44s %-7s %s\n", jobs[i].label(), r.Output, static, strings.Join(env, " "))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Packages: .pkg and .dmg for darwin targets, built with pkgbuild and
+   hdiutil
+   ------------------------------------------------------------------ */
+
+// macPackage is packages.macos with the target's macos block on top.
+func macPackage(cfg *Config, t Target) *MacPackage {
+	if cfg.Packages.MacOS == nil && t.MacOS == nil {
+		return nil
+	}
+	var m MacPackage
+	if cfg.Packages.MacOS != nil {
+		m = *cfg.Packages.MacOS
+	}
+	if o := t.MacOS; o != nil {
+		if len(o.Formats) > 0 {
+			m.Formats = o.Formats
+		}
+		m.Identifier = firstNonEmpty(o.Identifier, m.Identifier)
+		m.InstallLocation = firstNonEmpty(o.InstallLocation, m.InstallLocation)
+		m.VolumeName = firstNonEmpty(o.VolumeName, m.VolumeName)
+		m.SignIdentity = firstNonEmpty(o.SignIdentity, m.SignIdentity)
+	}
+	return &m
+}
+
+// macPackageArgs is the command line building format from the staging
+// directory root.
+func macPackageArgs(cfg *Config, j buildJob, mp *MacPackage, format, root, out string) []string {
+	if format == "dmg" {
+		return []string{"hdiutil", "create", "-volname", firstNonEmpty(mp.VolumeName, packageName(cfg, j)),
+			"-srcfolder", root, "-ov", "-format", "UDZO", out}
+	}
+	args := []string{"pkgbuild", "--root", root, "--identifier", mp.Identifier, "--version", packageVersion(cfg),
+		"--install-location", firstNonEmpty(mp.InstallLocation, "/usr/local/bin")}
+	if mp.SignIdentity != "" {
+		args = append(args, "--sign", mp.SignIdentity)
+	}
+	return append(args, out)
+}
+
+// buildMacPackages wraps every darwin binary into the formats of its
+// macos settings, writing name_version_arch.<format> next to the binary.
+// pkgbuild and hdiutil only exist on macOS: elsewhere, including the
+// builder container, the packages are skipped with a warning.
+func buildMacPackages(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	type pkgJob struct {
+		j  buildJob
+		mp *MacPackage
+	}
+	var todo []pkgJob
+	for _, j := range packageJobs(jobs, "darwin") {
+		mp := macPackage(cfg, j.Target)
+		if mp == nil || len(mp.Formats) == 0 {
+			continue
+		}
+		for _, f := range mp.Formats {
+			if f != "pkg" && f != "dmg" {
+				return fmt.Errorf("%s: packages.macos.formats: want pkg | dmg, got %q", j.label(), f)
+			}
+			if f == "pkg" && mp.Identifier == "" {
+				return fmt.Errorf("%s: packages.macos.identifier is required for pkg (com.example.%s)", j.label(), packageName(cfg, j))
+			}
+		}
+		todo = append(todo, pkgJob{j, mp})
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: macOS packages")
+		for _, t := range todo {
+			for _, f := range t.mp.Formats {
+				out := packageFile(cfg, t.j, t.j.Target.Arch, f)
+				fmt.Fprintln(textOut, strings.Join(macPackageArgs(cfg, t.j, t.mp, f, "<staging>", out), " "))
+			}
+		}
+		return nil
+	}
+	if runtime.GOOS != "darwin" {
+		fmt.Fprintln(textOut, "⚠ macOS packages skipped: pkgbuild and hdiutil need a macOS host (run go-builder --skip-docker on a Mac)")
+		return nil
+	}
+
+	fmt.Fprintln(textOut, ">>> macOS packages")
+	for _, t := range todo {
+		if _, err := os.Stat(t.j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		if err := macPackageJob(cfg, t.j, t.mp, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// macPackageJob stages the binary of j under its installed name and runs
+// pkgbuild / hdiutil on the staging directory.
+func macPackageJob(cfg *Config, j buildJob, mp *MacPackage, m *Manifest) error {
+	root, err := os.MkdirTemp("", "go-builder-macos-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	b, err := os.ReadFile(j.Out)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(root, binaryName(j.Cfg)), b, 0o755); err != nil {
+		return err
+	}
+	for _, f := range mp.Formats {
+		out := packageFile(cfg, j, j.Target.Arch, f)
+		args := macPackageArgs(cfg, j, mp, f, root, out)
+		if b, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s %s: %v: %s", args[0], j.label(), err, strings.TrimSpace(string(b)))
+		}
+		if err := recordPackage(m, j, f, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,8 @@
 // main.go
 //
 // go-builder entry-point.
-// • CLI flags (--init, --dry-run, --env, --skip-docker, --force)
+// • CLI flags (--init, --dry-run, --env, --skip-docker, --force, --offline, --watch)
+// • Subcommands (shell, run, list, inspect, cache, deps, proxy)
 // • Docker-aware build path
 // • Environment diff printing in dry-run
 // • Optional “verify_static” check per-target or global
@@ -9,15 +10,16 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	_ "embed"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -35,19 +37,79 @@ var (
 	dryRun     = flag.Bool("dry-run", false, "Print commands only (-n)")
 	envMode    = flag.String("env", "diff", "Env output: diff | all | none")
 	skipDocker = flag.Bool("skip-docker", false, "Ignore docker section (-D)")
+	purgeCache = flag.Bool("purge-cache", false, "Empty the docker module and build caches before building")
+	dockerDbg  = flag.Bool("docker-debug", false, "Keep the build container after a failure and open a shell in it")
+	skipTests  = flag.Bool("skip-tests", false, "Skip the test gate")
+	skipChecks = flag.Bool("skip-checks", false, "Skip the checks gate (vet, linters)")
+	skipBench  = flag.Bool("skip-bench", false, "Skip the bench regression gate")
+	output     = flag.String("output", "text", "Output format: text | json (NDJSON build events on stdout, or the report of list / deps)")
+	jsonOut    = flag.Bool("json", false, "Short for --output json")
+	skipPre    = flag.Bool("skip-preflight", false, "Skip CGO toolchain preflight checks")
+	offline    = flag.Bool("offline", false, "Never use the network; fail early on steps that need it")
+	parallelN  = flag.Int("parallel", 0, "Build up to N targets concurrently (default build.parallel or 1)")
+	sizeRep    = flag.Bool("size-report", false, "Print the largest packages of each built binary")
+	sizeTop    = flag.Int("size-top", 15, "Number of packages shown by --size-report")
+	keepGoing  = flag.Bool("keep-going", false, "Build remaining targets after a failure, report all at the end")
+	watch      = flag.Bool("watch", false, "Rebuild the host target on every source change (local build)")
+	buildOnly  = flag.Bool("build-only", false, "Only build: no gates, manifest, checksums or signing (per-target containers)")
+	gatesOnly  = flag.Bool("gates-only", false, "Only run the gates (before per-target containers)")
+	finalize   = flag.Bool("finalize", false, "Don't build or run gates: record the existing artifacts, then run post-build steps")
+	skipImages = flag.Bool("skip-images", false, "Don't build the runtime images of the images section")
+	targetSel  listFlag
 )
 
+// innerFlags are the flags the go-builder run inside the builder
+// container honours; the ones set on the command line are passed on.
+var innerFlags = []string{"skip-tests", "skip-checks", "skip-bench", "skip-preflight", "size-report", "size-top",
+	"keep-going", "parallel", "output", "json", "offline"}
+
+// forwardedFlags renders the innerFlags set on the command line.
+func forwardedFlags() string {
+	var s string
+	flag.Visit(func(f *flag.Flag) {
+		switch v := f.Value.String(); {
+		case !slices.Contains(innerFlags, f.Name):
+		case v == "true":
+			s += " --" + f.Name
+		default:
+			s += " --" + f.Name + "=" + shellQuote(v)
+		}
+	})
+	return s
+}
+
+// listFlag collects a repeatable string flag.
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
 func init() {
 	flag.BoolVar(initCfg, "i", false, "Alias for --init")
 	flag.BoolVar(force, "f", false, "Alias for --force")
 	flag.BoolVar(dryRun, "n", false, "Alias for --dry-run")
 	flag.BoolVar(skipDocker, "D", false, "Alias for --skip-docker")
+	flag.Var(&targetSel, "target", "Only build targets matching os/arch (glob, repeatable)")
 }
 
 /*──────────────────────── main ───────────────────────────────*/
 func main() {
 	flag.Parse()
 
+	/* optional subcommand; flags may follow it and its arguments */
+	cmdName := flag.Arg(0)
+	var cmdArgs []string
+	if cmdName != "" {
+		cmdArgs = parseInterleaved(flag.Args()[1:])
+	}
+	switch *output {
+	case "text":
+	case "json":
+		*jsonOut = true
+	default:
+		log.Fatalf("go-builder: --output: want text | json, got %q", *output)
+	}
+
 	/* template generation */
 	if *initCfg {
 		if err := createExampleConfig(".gobuilder.yml", *force); err != nil {
@@ -57,24 +119,216 @@ func main() {
 		return
 	}
 
+	/* commands that work without a config */
+	if *offline && (cmdName == "deps" || (cmdName == "proxy" && len(cmdArgs) > 0 && cmdArgs[0] == "warm")) {
+		log.Fatalf("go-builder: %s needs the network, not available with --offline", strings.Join(append([]string{cmdName}, cmdArgs...), " "))
+	}
+	if cmdName == "deps" {
+		if err := depsCmd(cmdArgs, *jsonOut); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	}
+	if cmdName == "inspect" {
+		if len(cmdArgs) != 1 {
+			log.Fatalf("go-builder: usage: go-builder inspect <binary>")
+		}
+		buildDir := "builds"
+		if cfg, err := LoadConfig(*cfgPath); err == nil {
+			buildDir = expandEnv(cfg).BuildDir
+		}
+		if err := inspectCmd(cmdArgs[0], buildDir); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	}
+
 	/* load config */
 	cfg, err := LoadConfig(*cfgPath)
 	if err != nil {
 		log.Fatalf("go-builder: %v", err)
 	}
+	bins, err := cfg.binaries()
+	if err != nil {
+		log.Fatalf("go-builder: %v", err)
+	}
+	for i := range bins {
+		if bins[i].Build.Reproducible {
+			setSourceDateEpoch()
+		}
+		bins[i] = expandEnv(bins[i])
+		if err := bins[i].renderVars(); err != nil {
+			log.Fatalf("go-builder: build.vars: %v", err)
+		}
+	}
 	cfg = expandEnv(cfg)
+	if cfg.Docker != nil {
+		if err := normalizeDocker(cfg.Docker); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Docker != nil && cfg.Docker.Namespace != "" {
+		os.Setenv("CONTAINERD_NAMESPACE", cfg.Docker.Namespace) // read by every nerdctl call
+	}
 	if cfg.Build.Debug {
 		*dryRun = true
 	}
 
+	switch cmdName {
+	case "", "run":
+	case "shell":
+		if err := shellCmd(cfg); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	case "cache":
+		if err := cacheCmd(cfg, cmdArgs, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	case "list":
+		if err := listCmd(cfg, bins, targetSel, *jsonOut); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	case "proxy":
+		if err := proxyCmd(cfg, cmdArgs, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	case "size-diff":
+		if err := sizeDiffCmd(cfg, cmdArgs); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("go-builder: unknown command %q", cmdName)
+	}
+	if *jsonOut {
+		enableEvents(os.Stdout)
+	}
+
 	/* docker path */
-	if cfg.Docker != nil && !*skipDocker {
-		inner := append([]string{}, cfg.Docker.Setup...)
-		inner = append(inner, "go install github.com/pablolagos/go-builder@latest")
-		inner = append(inner, "go-builder --skip-docker --config=.gobuilder.yml")
-		if err := dockerRun(cfg, inner, *dryRun); err != nil {
+	useDocker := cfg.Docker != nil && !*skipDocker && !*watch && cmdName != "run"
+	if *offline {
+		if problems := offlineProblems(cfg, useDocker); len(problems) > 0 {
+			log.Fatalf("go-builder: --offline: these steps need the network:\n  %s", strings.Join(problems, "\n  "))
+		}
+	}
+	var rt containerRuntime
+	if useDocker {
+		if rt, err = selectRuntime(cfg.Docker.Runtime); err != nil {
 			log.Fatalf("go-builder: %v", err)
 		}
+		if err := useEngine(cfg, rt); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if useDocker && !*dryRun {
+		if err := rt.Ping(); err != nil {
+			switch cfg.Docker.Fallback {
+			case "", "fail":
+				log.Fatalf("go-builder: %v", err)
+			case "warn":
+				log.Printf("go-builder: warning: %v — building locally instead", err)
+			case "local":
+				fmt.Fprintf(textOut, "%s unavailable, building locally\n", rt.Name())
+			}
+			useDocker = false
+		}
+	}
+	if useDocker {
+		innerArgs := " --skip-docker --config=.gobuilder.yml" + forwardedFlags()
+		if cfg.Docker.Network == "none" && !*offline {
+			innerArgs += " --offline" // fail early on anything that would need the network
+		}
+		if cfg.Images != nil {
+			innerArgs += " --skip-images" // built below, by the host's engine
+		}
+		groups, err := dockerGroups(cfg, bins, targetSel)
+		if err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if !*dryRun && !*skipPre {
+			sections := []*DockerSection{cfg.Docker}
+			for _, g := range groups {
+				sections = append(sections, g.docker)
+			}
+			if err := dockerPreflight(rt, sections); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+
+		if *purgeCache {
+			if err := purgeCacheMounts(cfg, rt, *dryRun); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+		if cfg.Docker.Auth != nil {
+			if err := registryLogin(cfg.Docker, rt, *dryRun); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+		if cfg.Docker.Dockerfile != "" {
+			if err := buildImage(cfg.Docker, rt, *dryRun); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+
+		var builder *BuilderImage
+		if cfg.Docker.VerifyDigest && !*dryRun {
+			digest, err := imageDigest(rt, cfg.Docker)
+			if err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+			fmt.Fprintf(textOut, "builder image %s\n", digest)
+			builder = &BuilderImage{Runtime: rt.Name(), Image: cfg.Docker.Image, Digest: digest}
+			for _, g := range groups {
+				if g.docker.Image == cfg.Docker.Image {
+					g.docker.Image = digest
+				}
+			}
+			cfg.Docker.Image = digest // run exactly what was verified
+		}
+		// every run below needs the final image
+		if err := resolveShell(cfg.Docker, rt, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if cfg.Docker.Network == "none" {
+			if err := prepareNoNetwork(cfg, rt, *offline, *dryRun); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+		if groups != nil {
+			parallel := *parallelN
+			if parallel == 0 {
+				parallel = cfg.Build.Parallel
+			}
+			err = runDockerGroups(cfg, rt, groups, innerArgs, parallel, *keepGoing || cfg.Build.ContinueOnError, *dockerDbg, *dryRun)
+		} else {
+			err = dockerRun(cfg, rt, innerScript(cfg.Docker, innerArgs, targetSel), *dockerDbg, *dryRun)
+		}
+		if err != nil {
+			emit(event{Event: "finish", Result: "error", Error: err.Error()})
+			log.Fatalf("go-builder: %v", err)
+		}
+		if builder != nil {
+			m, err := loadManifest(cfg.BuildDir)
+			if err == nil {
+				m.Builder = builder
+				err = m.save(cfg.BuildDir)
+			}
+			if err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+		if cfg.Images != nil && !*skipImages {
+			if err := hostImages(cfg, rt, bins, targetSel, *dryRun); err != nil {
+				emit(event{Event: "finish", Result: "error", Error: err.Error()})
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+		emit(event{Event: "finish", Result: "ok"})
 		return
 	}
 
@@ -83,85 +337,285 @@ func main() {
 		log.Fatalf("go-builder: %v", err)
 	}
 	baseEnv := sliceToMap(os.Environ())
+	globalEnv, err := cfg.Env.resolve(*dryRun)
+	if err != nil {
+		log.Fatalf("go-builder: %v", err)
+	}
+	// Inside a container the mounted cache is already in GOPROXY.
+	if proxyEnabled(cfg) && os.Getenv("GOPROXY") != proxyURL(proxyContainerDir) {
+		dir, _ := filepath.Abs(proxyDir(cfg))
+		globalEnv["GOPROXY"] = proxyURL(dir)
+	}
+	if cfg.GoVersion != "" {
+		goroot, err := ensureToolchain(cfg.GoVersion, *offline, *dryRun)
+		if err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		goBin = filepath.Join(goroot, "bin", exeName("go"))
+		globalEnv["GOROOT"] = goroot
+		globalEnv["GOTOOLCHAIN"] = "local"
+		globalEnv["PATH"] = filepath.Join(goroot, "bin") + string(os.PathListSeparator) + baseEnv["PATH"]
+	}
+	if *offline {
+		offlineEnv(cfg, baseEnv, globalEnv)
+		if err := offlineVerify(envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
 
-	baseName := cfg.Output
-	if baseName == "" {
-		baseName = filepath.Base(cfg.Source)
+	// per-target containers: the gates ran in a --gates-only run first
+	gates := !*buildOnly && !*finalize
+	if cfg.Modules != nil && cfg.Modules.Verify && gates {
+		if err := runModVerify(cfg, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
 	}
 
-	runSingle := func(env map[string]string, out string, wantStatic bool) {
-		if err := runBuild(cfg, baseEnv, envSlice(env), out, *dryRun); err != nil {
+	if cfg.Output.Umask != "" {
+		if err := setUmask(cfg.Output.Umask); err != nil {
 			log.Fatalf("go-builder: %v", err)
 		}
-		if wantStatic {
-			if err := assertStatic(out, *dryRun); err != nil {
-				log.Fatalf("go-builder: %v", err)
-			}
+	}
+	if !*finalize {
+		if err := runAssets(cfg, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
 		}
 	}
 
-	if len(cfg.Targets) == 0 { /* host build */
-		out := filepath.Join(cfg.BuildDir, runtime.GOOS, runtime.GOARCH, baseName)
-		if runtime.GOOS == "windows" && !strings.HasSuffix(out, ".exe") {
-			out += ".exe"
+	if cfg.Checks != nil && !*skipChecks && gates {
+		if err := runChecks(cfg, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Licenses != nil && gates {
+		var sources []string
+		for _, b := range bins {
+			sources = append(sources, b.Source)
+		}
+		if err := runLicenseScan(cfg, sources, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Test != nil && !*skipTests && gates {
+		if err := runTestGate(cfg, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Bench != nil && !*skipBench && gates {
+		if err := runBenchGate(cfg, envSlice(mergeEnvLayers(baseEnv, globalEnv, nil)), *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
 		}
-		env := mergeEnvLayers(baseEnv, cfg.Env, nil)
-		runSingle(env, out, cfg.Build.VerifyStatic)
+	}
+
+	if *gatesOnly {
 		return
 	}
 
-	for _, t := range cfg.Targets {
-		env := mergeEnvLayers(baseEnv, cfg.Env, t.Env)
-		env["GOOS"], env["GOARCH"] = t.OS, t.Arch
-		out := t.Output
-		if out == "" {
-			out = filepath.Join(cfg.BuildDir, t.OS, t.Arch, baseName)
-			if t.OS == "windows" && !strings.HasSuffix(out, ".exe") {
-				out += ".exe"
-			}
+	manifest, err := loadManifest(cfg.BuildDir)
+	if err != nil {
+		log.Fatalf("go-builder: %v", err)
+	}
+	manifest.Builder = nil // set by the outer process for docker builds
+
+	var jobs []buildJob
+	for _, bc := range bins {
+		js, err := planJobs(bc, baseEnv, globalEnv, *dryRun)
+		if err != nil {
+			log.Fatalf("go-builder: %v", err)
 		}
-		fmt.Printf(">>> Building %s/%s → %s\n", t.OS, t.Arch, out)
+		jobs = append(jobs, js...)
+	}
+	if jobs, err = selectJobs(jobs, targetSel); err != nil {
+		log.Fatalf("go-builder: %v", err)
+	}
+	if !*dryRun && !*skipPre && !*finalize {
+		if err := cgoPreflight(jobs); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
 
-		wantStatic := cfg.Build.VerifyStatic
-		if t.VerifyStatic != nil {
-			wantStatic = *t.VerifyStatic
+	if cmdName == "run" {
+		code, err := runCmd(cfg, jobs, baseEnv, manifest, cmdArgs, *watch, *dryRun)
+		if err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		os.Exit(code)
+	}
+	if *watch {
+		if err := watchBuild(cfg, hostJobs(jobs), baseEnv, manifest, *dryRun, nil); err != nil {
+			log.Fatalf("go-builder: %v", err)
 		}
+		return
+	}
 
-		runSingle(env, out, wantStatic)
+	parallel := *parallelN
+	if parallel == 0 {
+		parallel = cfg.Build.Parallel
+	}
+	if *buildOnly {
+		if err := runJobs(jobs, baseEnv, &Manifest{}, parallel, *keepGoing || cfg.Build.ContinueOnError, *dryRun); err != nil {
+			emit(event{Event: "finish", Result: "error", Error: err.Error()})
+			log.Fatalf("go-builder: %v", err)
+		}
+		emit(event{Event: "finish", Result: "ok"})
+		return
+	}
+	var buildErr error
+	if *finalize {
+		buildErr = recordExisting(jobs, manifest, *dryRun)
+	} else {
+		buildErr = runJobs(jobs, baseEnv, manifest, parallel, *keepGoing || cfg.Build.ContinueOnError, *dryRun)
+	}
+	if !*dryRun {
+		// saved even after a failure: it records what did build
+		if err := manifest.save(cfg.BuildDir); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
 	}
+	if buildErr != nil {
+		emit(event{Event: "finish", Result: "error", Error: buildErr.Error()})
+		log.Fatalf("go-builder: %v", buildErr)
+	}
+	if cfg.Packages != nil {
+		if err := buildPackages(cfg, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if !*dryRun {
+			if err := manifest.save(cfg.BuildDir); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+	}
+	if cfg.Provenance != nil {
+		if err := writeProvenance(cfg, *cfgPath, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.SizeDiff != nil && !*dryRun {
+		if err := recordSizes(cfg, manifest); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if *sizeRep {
+		if err := sizeReport(jobs, *sizeTop, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if len(cfg.DepsReport) > 0 {
+		if err := writeDepsReport(cfg, cfg.DepsReport, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Checksums != nil {
+		if err := writeChecksums(cfg, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Sign != nil && cfg.Sign.Cosign != nil {
+		if err := runCosign(cfg, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Sign != nil && cfg.Sign.GPG != nil {
+		if err := runGPG(cfg, jobs, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	if cfg.Sign != nil && cfg.Sign.Rekor != nil {
+		if err := runRekor(cfg, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if !*dryRun {
+			if err := manifest.save(cfg.BuildDir); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+	}
+	if cfg.Images != nil && !*skipImages {
+		rt, err := imageRuntime(cfg)
+		if err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if err := buildRuntimeImages(cfg, rt, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+		if !*dryRun {
+			if err := manifest.save(cfg.BuildDir); err != nil {
+				log.Fatalf("go-builder: %v", err)
+			}
+		}
+	}
+	if cfg.Homebrew != nil {
+		if err := writeHomebrew(cfg, jobs, manifest, *dryRun); err != nil {
+			log.Fatalf("go-builder: %v", err)
+		}
+	}
+	emit(event{Event: "finish", Result: "ok"})
 }
 
-/*──────────────────────── build executor ─────────────────────*/
-func runBuild(cfg *Config, base map[string]string, env []string, out string, dry bool) error {
-	args := []string{"build"}
-	if cfg.Build.Verbose {
-		args = append(args, "-v")
+/*──────────────────────── subcommands ────────────────────────*/
+
+// parseInterleaved parses flags mixed with positional arguments and
+// returns the positionals; everything after "--" is kept verbatim.
+func parseInterleaved(args []string) []string {
+	var pos []string
+	for len(args) > 0 {
+		flag.CommandLine.Parse(args)
+		rest := flag.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...)
+		}
+		if len(rest) == 0 {
+			break
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
 	}
-	if len(cfg.Build.Tags) > 0 {
-		args = append(args, "-tags", strings.Join(cfg.Build.Tags, ","))
+	return pos
+}
+
+// shellCmd drops into the builder container for debugging.
+func shellCmd(cfg *Config) error {
+	if cfg.Docker == nil {
+		return fmt.Errorf("shell needs a docker section in %s", *cfgPath)
 	}
-	if cfg.Build.TrimPath {
-		args = append(args, "-trimpath")
+	rt, err := selectRuntime(cfg.Docker.Runtime)
+	if err != nil {
+		return err
 	}
-	if cfg.Build.GcFlags != "" {
-		args = append(args, "-gcflags", cfg.Build.GcFlags)
+	if err := useEngine(cfg, rt); err != nil {
+		return err
 	}
-	if cfg.Build.AsmFlags != "" {
-		args = append(args, "-asmflags", cfg.Build.AsmFlags)
+	if cfg.Docker.remote {
+		return fmt.Errorf("shell needs a local engine: the sources are bind-mounted")
 	}
-	if cfg.Build.Mod != "" {
-		args = append(args, "-mod", cfg.Build.Mod)
+	if !*dryRun && !*skipPre {
+		if err := dockerPreflight(rt, []*DockerSection{cfg.Docker}); err != nil {
+			return err
+		}
 	}
-	if cfg.Build.Race {
-		args = append(args, "-race")
+	if cfg.Docker.Auth != nil {
+		if err := registryLogin(cfg.Docker, rt, *dryRun); err != nil {
+			return err
+		}
 	}
-	if lf := composeLdflags(cfg.Build.LdFlags, cfg.Build.Vars); lf != "" {
-		args = append(args, "-ldflags", lf)
+	if cfg.Docker.Dockerfile != "" {
+		if err := buildImage(cfg.Docker, rt, *dryRun); err != nil {
+			return err
+		}
 	}
-	if out != "" {
-		args = append(args, "-o", out)
+	if err := resolveShell(cfg.Docker, rt, *dryRun); err != nil {
+		return err
+	}
+	return dockerShell(cfg, rt, *dryRun)
+}
+
+/*──────────────────────── build executor ─────────────────────*/
+func runBuild(cfg *Config, t Target, base map[string]string, env []string, out string, w io.Writer, dry bool) error {
+	bin, args, err := buildCommand(cfg, t, out)
+	if err != nil {
+		return err
 	}
-	args = append(args, cfg.Source)
 
 	if dry {
 		cur := sliceToMap(env)
@@ -174,7 +628,7 @@ func runBuild(cfg *Config, base map[string]string, env []string, out string, dry
 		default:
 			show = diffEnv(base, cur)
 		}
-		fmt.Println("\n# Dry-run:")
+		fmt.Fprintln(w, "\n# Dry-run:")
 		if show != nil {
 			keys := make([]string, 0, len(show))
 			for k := range show {
@@ -182,36 +636,48 @@ func runBuild(cfg *Config, base map[string]string, env []string, out string, dry
 			}
 			sort.Strings(keys)
 			for _, k := range keys {
-				fmt.Printf("%s=%q \\\n", k, show[k])
+				fmt.Fprintf(w, "%s=%q \\\n", k, show[k])
 			}
 		}
-		fmt.Printf("go %s\n\n", strings.Join(args, " "))
+		fmt.Fprintf(w, "%s %s\n\n", bin, strings.Join(args, " "))
 		return nil
 	}
 
+	b := t.build(cfg.Build)
+	timeout, err := parseTimeout(b.Timeout)
+	if err != nil {
+		return err
+	}
+	emit(event{Event: "command", Target: jobLabel(cfg, t), Command: bin + " " + strings.Join(args, " ")})
 	start := time.Now()
-	cmd := exec.Command("go", args...)
-	cmd.Env = env
-	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-	if err := cmd.Run(); err != nil {
+	err = runRetry(func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, bin, args...)
+		cmd.Env = env
+		cmd.Stdout, cmd.Stderr = w, stderrFor(w)
+		return cmd
+	}, timeout, b.Retries, w)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("✔ completed in %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Fprintf(w, "✔ completed in %s\n", time.Since(start).Round(time.Millisecond))
 	return nil
 }
 
 /*──────────────────────── static checker ─────────────────────*/
-func assertStatic(path string, dry bool) error {
+func assertStatic(path string, w io.Writer, dry bool) error {
 	if dry {
-		fmt.Printf("# Dry-run: verifying %s is static\n", path)
+		fmt.Fprintf(w, "# Dry-run: verifying %s is static\n", path)
 		return nil
 	}
-	out, err := exec.Command("file", "-L", path).Output()
+	bin, err := readBinary(path)
 	if err != nil {
-		return fmt.Errorf("file check failed: %w", err)
+		return fmt.Errorf("static check failed: %w", err)
 	}
-	if !bytes.Contains(out, []byte("statically linked")) {
-		return fmt.Errorf("%s is NOT statically linked", path)
+	if !bin.Static {
+		if len(bin.Libs) == 0 {
+			return fmt.Errorf("%s is NOT statically linked (has a dynamic loader)", path)
+		}
+		return fmt.Errorf("%s is NOT statically linked (needs %s)", path, strings.Join(bin.Libs, ", "))
 	}
 	return nil
 }
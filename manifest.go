@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Build manifest: build_dir/manifest.json records what a run produced
+   ------------------------------------------------------------------ */
+
+const manifestName = "manifest.json"
+
+// Manifest is the machine-readable record of one go-builder run.
+type Manifest struct {
+	Created    time.Time           `json:"created"`
+	Builder    *BuilderImage       `json:"builder,omitempty"`
+	Artifacts  []ManifestArtifact  `json:"artifacts"`
+	Rekor      []RekorEntry        `json:"rekor,omitempty"`       // transparency log entries (sign.rekor)
+	Images     []ManifestImage     `json:"images,omitempty"`      // runtime images (images:)
+	ImageLists []ManifestImageList `json:"image_lists,omitempty"` // multi-arch tags over Images
+}
+
+// ManifestImage is a runtime image built from one target's binary.
+type ManifestImage struct {
+	Target   string   `json:"target"`
+	Platform string   `json:"platform"` // linux/arm64, linux/arm/v7
+	Tags     []string `json:"tags"`     // repository:tag references
+	Pushed   bool     `json:"pushed,omitempty"`
+	Tarball  string   `json:"tarball,omitempty"` // images.tarball
+}
+
+// BuilderImage identifies the container image a docker build ran in.
+type BuilderImage struct {
+	Runtime string `json:"runtime"`
+	Image   string `json:"image"`
+	Digest  string `json:"digest"` // repo@sha256:…
+}
+
+// ManifestArtifact is one produced binary or package.
+type ManifestArtifact struct {
+	Target string `json:"target"` // os/arch or tinygo board
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	Kind  string         `json:"kind,omitempty"`  // package format (deb, rpm, …); empty for binaries
+	Cover *ArtifactCover `json:"cover,omitempty"` // set for -cover builds
+}
+
+// RekorEntry is one signature or attestation in the transparency log.
+type RekorEntry struct {
+	Path     string `json:"path"` // signed file
+	Kind     string `json:"kind"` // cosign | gpg | provenance
+	LogIndex int64  `json:"log_index"`
+	URL      string `json:"url,omitempty"` // entry location
+}
+
+// ArtifactCover tells integration environments how to collect coverage
+// from an instrumented binary.
+type ArtifactCover struct {
+	GoCoverDir string   `json:"gocoverdir,omitempty"` // run with GOCOVERDIR=<this>
+	Packages   []string `json:"packages,omitempty"`   // -coverpkg; default: the main module
+}
+
+// loadManifest reads build_dir/manifest.json; a missing file yields an
+// empty manifest.
+func loadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestName, err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) save(dir string) error {
+	m.Created = sourceDate()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, manifestName), append(b, '\n'), 0o644)
+}
+
+// addArtifact hashes path and records it, replacing an older entry.
+func (m *Manifest) addArtifact(target, path string, cover *ArtifactCover) (ManifestArtifact, error) {
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return ManifestArtifact{}, err
+	}
+	a := ManifestArtifact{Target: target, Path: filepath.ToSlash(path), Size: size, SHA256: sum, Cover: cover}
+	// log entries of an older build of this file are stale now
+	m.Rekor = slices.DeleteFunc(m.Rekor, func(e RekorEntry) bool { return e.Path == a.Path })
+	for i := range m.Artifacts {
+		if m.Artifacts[i].Path == a.Path {
+			m.Artifacts[i] = a
+			return a, nil
+		}
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	return a, nil
+}
+
+// addPackage records a package built from target's binary.
+func (m *Manifest) addPackage(target, kind, path string) (ManifestArtifact, error) {
+	a, err := m.addArtifact(target, path, nil)
+	if err != nil {
+		return a, err
+	}
+	i := slices.IndexFunc(m.Artifacts, func(x ManifestArtifact) bool { return x.Path == a.Path })
+	m.Artifacts[i].Kind = kind
+	return m.Artifacts[i], nil
+}
+
+// ManifestImageList is a pushed multi-arch tag and the per-platform
+// images it points to.
+type ManifestImageList struct {
+	Ref     string   `json:"ref"`
+	Sources []string `json:"sources"`
+}
+
+// addImageList records a manifest list, replacing one of the same ref.
+func (m *Manifest) addImageList(l ManifestImageList) {
+	m.ImageLists = slices.DeleteFunc(m.ImageLists, func(o ManifestImageList) bool { return o.Ref == l.Ref })
+	m.ImageLists = append(m.ImageLists, l)
+}
+
+// addImage records an image, replacing the previous one of its target.
+func (m *Manifest) addImage(img ManifestImage) {
+	m.Images = slices.DeleteFunc(m.Images, func(o ManifestImage) bool { return o.Target == img.Target })
+	m.Images = append(m.Images, img)
+}
+
+// addRekor records a log entry, replacing one of the same file and kind.
+func (m *Manifest) addRekor(e RekorEntry) {
+	e.Path = filepath.ToSlash(e.Path)
+	m.Rekor = slices.DeleteFunc(m.Rekor, func(o RekorEntry) bool { return o.Path == e.Path && o.Kind == e.Kind })
+	m.Rekor = append(m.Rekor, e)
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
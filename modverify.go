@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Module integrity: go mod download + go mod verify before building
+   ------------------------------------------------------------------ */
+
+// runModVerify downloads every module (retrying network failures) and
+// checks the module cache against go.sum, so a tampered or stale go.sum
+// stops the run before any target is built.
+func runModVerify(cfg *Config, env []string, dry bool) error {
+	retries := cfg.Modules.Retries
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: module verification (%d retries)\ngo mod download -x\ngo mod verify\n", retries)
+		return nil
+	}
+
+	fmt.Fprintln(textOut, ">>> Modules")
+	var out []byte
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(textOut, "↻ retry %d/%d: go mod download\n", attempt, retries)
+		}
+		out, err = goModCmd(env, textOut, "download", "-x")
+		if err == nil || checksumMismatch(out) {
+			break // a go.sum mismatch won't go away by retrying
+		}
+	}
+	if err != nil {
+		if checksumMismatch(out) {
+			return fmt.Errorf("go.sum does not match the downloaded modules; check the dependency " +
+				"source or refresh go.sum with `go mod tidy` if the change is expected")
+		}
+		return fmt.Errorf("go mod download: %w", err)
+	}
+	if out, err = goModCmd(env, textOut, "verify"); err != nil {
+		return fmt.Errorf("go mod verify: module cache was modified after download "+
+			"(clear it with `go clean -modcache`): %w", err)
+	}
+	return nil
+}
+
+// goModCmd runs `go mod args…`, teeing its combined output to w.
+func goModCmd(env []string, w io.Writer, args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command(goBin, append([]string{"mod"}, args...)...)
+	cmd.Env = env
+	cmd.Stdout = io.MultiWriter(&buf, w)
+	cmd.Stderr = io.MultiWriter(&buf, os.Stderr)
+	err := cmd.Run()
+	return buf.Bytes(), err
+}
+
+func checksumMismatch(out []byte) bool {
+	s := string(out)
+	return strings.Contains(s, "checksum mismatch") || strings.Contains(s, "SECURITY ERROR")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   --offline: air-gapped builds that never touch the network
+   ------------------------------------------------------------------ */
+
+// vendored reports whether the module in the working directory vendors
+// its dependencies.
+func vendored() bool {
+	fi, err := os.Stat("vendor/modules.txt")
+	return err == nil && !fi.IsDir()
+}
+
+// offlineEnv pins the go command to local sources: vendor/ when present,
+// otherwise the module cache or the local proxy cache.
+func offlineEnv(cfg *Config, base, env map[string]string) {
+	if !proxyEnabled(cfg) {
+		env["GOPROXY"] = "off"
+	}
+	env["GOTOOLCHAIN"] = "local"
+	if flags := firstNonEmpty(env["GOFLAGS"], base["GOFLAGS"]); vendored() && !strings.Contains(flags, "-mod=") {
+		env["GOFLAGS"] = strings.TrimSpace(flags + " -mod=vendor")
+	}
+}
+
+// offlineProblems lists configured steps that would need the network.
+func offlineProblems(cfg *Config, useDocker bool) []string {
+	var out []string
+	if useDocker {
+		if selfBinary(cfg.Docker) == "" {
+			out = append(out, "docker: the container installs go-builder (run a static linux go-builder, or --skip-docker)")
+		}
+		if cfg.Docker.Auth != nil && cfg.Docker.Auth.Username != "" {
+			out = append(out, "docker.auth: registry login")
+		}
+		if cfg.Docker.Dockerfile != "" {
+			out = append(out, "docker.dockerfile: building the image may pull its base")
+		}
+		if cfg.Docker.Pull == "always" {
+			out = append(out, "docker.pull: always")
+		}
+	}
+	if cfg.Sign != nil && cfg.Sign.Cosign != nil {
+		// a key file signs locally: no tlog upload without sign.rekor
+		switch key := cfg.Sign.Cosign.Key; {
+		case key == "":
+			out = append(out, "sign.cosign: keyless signing (OIDC, Fulcio; set sign.cosign.key)")
+		case strings.Contains(key, "://"):
+			out = append(out, "sign.cosign.key: KMS key")
+		}
+	}
+	if cfg.Sign != nil && cfg.Sign.Rekor != nil {
+		out = append(out, "sign.rekor: transparency log upload")
+	}
+	if cfg.Homebrew != nil && cfg.Homebrew.Tap != "" {
+		out = append(out, "homebrew.tap: cloning and pushing the tap")
+	}
+	if cfg.Images != nil && !*skipImages {
+		if cfg.Images.Push {
+			out = append(out, "images.push: pushing the runtime images")
+			if imageLists(cfg.Images) {
+				out = append(out, "images.manifest: creating the multi-arch lists on the registry")
+			}
+		}
+		if base := firstNonEmpty(cfg.Images.Base, defaultImageBase); base != "scratch" && !localImage(cfg, base) {
+			out = append(out, "images: pulling the base "+base+" (pull it while online, or images.base: scratch)")
+		}
+	}
+	return out
+}
+
+// localImage reports whether the engine building the runtime images
+// already has ref. images.tarball always fetches the base from the
+// registry.
+func localImage(cfg *Config, ref string) bool {
+	if cfg.Images.Tarball != "" {
+		return false
+	}
+	rt, err := imageRuntime(cfg)
+	if err != nil {
+		return true // reported when the images are built
+	}
+	return exec.Command(rt.Bin(), "image", "inspect", ref).Run() == nil
+}
+
+// offlineVerify checks that every module the build needs is available
+// without downloading anything.
+func offlineVerify(env []string, dry bool) error {
+	if vendored() {
+		return nil // go build checks vendor/modules.txt itself
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: offline module check\ngo mod download")
+		return nil
+	}
+	cmd := exec.Command(goBin, "mod", "download")
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("offline: modules missing from the local cache (run `go mod download` or `go-builder proxy warm` while online):\n%s", out)
+	}
+	return nil
+}
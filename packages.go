@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* ------------------------------------------------------------------
+   Packages, built from the binaries after the build
+   ------------------------------------------------------------------ */
+
+// buildPackages builds every format configured in packages: from the
+// built binaries and records the packages in the manifest.
+func buildPackages(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	for _, build := range []func(*Config, []buildJob, *Manifest, bool) error{
+		buildLinuxPackages,
+		buildWindowsInstallers,
+		buildMacPackages,
+		buildSnaps,
+		buildAppImages,
+		buildFlatpaks,
+	} {
+		if err := build(cfg, jobs, m, dry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseVersion is version, else the latest git tag, without a leading
+// v: package managers and registries expect a number.
+func releaseVersion(cfg *Config) string {
+	return strings.TrimPrefix(firstNonEmpty(cfg.Version, currentMeta().GitTag, "0.0.0"), "v")
+}
+
+// packageVersion is packages.version, else releaseVersion.
+func packageVersion(cfg *Config) string {
+	if v := cfg.Packages.Version; v != "" {
+		return strings.TrimPrefix(v, "v")
+	}
+	return releaseVersion(cfg)
+}
+
+// packageName is packages.name, else the job's binary name.
+func packageName(cfg *Config, j buildJob) string {
+	return firstNonEmpty(cfg.Packages.Name, binaryName(j.Cfg))
+}
+
+// packageJobs are the jobs a package format applies to: executables
+// built for goos.
+func packageJobs(jobs []buildJob, goos string) []buildJob {
+	var out []buildJob
+	for _, j := range jobs {
+		if j.Target.OS == goos && !libraryMode(j.Target.build(j.Cfg.Build).BuildMode) {
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
+// packageFile is where a package of job j goes: next to its binary.
+func packageFile(cfg *Config, j buildJob, arch, ext string) string {
+	return filepath.Join(filepath.Dir(j.Out), fmt.Sprintf("%s_%s_%s.%s", packageName(cfg, j), packageVersion(cfg), arch, ext))
+}
+
+// recordPackage adds a built package to the manifest.
+func recordPackage(m *Manifest, j buildJob, kind, file string) error {
+	a, err := m.addPackage(j.Target.label(j.Cfg), kind, file)
+	if err != nil {
+		return err
+	}
+	emit(event{Event: "artifact", Target: j.label(), Artifact: a.Path, Size: a.Size, SHA256: a.SHA256})
+	fmt.Fprintf(textOut, "✔ packaged %s\n", file)
+	return nil
+}
+
+/* ------------------------------------------------------------------
+   Linux packages: deb, rpm and apk, built with nfpm
+   ------------------------------------------------------------------ */
+
+// nfpmConfig is the subset of nfpm's configuration go-builder writes.
+type nfpmConfig struct {
+	Name        string            `yaml:"name"`
+	Arch        string            `yaml:"arch"`
+	Platform    string            `yaml:"platform"`
+	Version     string            `yaml:"version"`
+	Maintainer  string            `yaml:"maintainer,omitempty"`
+	Description string            `yaml:"description,omitempty"`
+	Homepage    string            `yaml:"homepage,omitempty"`
+	License     string            `yaml:"license,omitempty"`
+	Depends     []string          `yaml:"depends,omitempty"`
+	Contents    []nfpmContent     `yaml:"contents"`
+	Scripts     map[string]string `yaml:"scripts,omitempty"`
+}
+
+type nfpmContent struct {
+	Src  string `yaml:"src"`
+	Dst  string `yaml:"dst"`
+	Type string `yaml:"type,omitempty"`
+}
+
+// nfpmArch is the nfpm spelling of a target's architecture: GOARCH, with
+// the GOARM level appended for arm (arm7).
+func nfpmArch(t Target) string {
+	if t.Arch == "arm" && t.Variant != "" {
+		return "arm" + strings.TrimPrefix(t.Variant, "v")
+	}
+	return t.Arch
+}
+
+// zshCompletionDir differs between Debian and everyone else.
+var zshCompletionDir = map[string]string{
+	"deb": "/usr/share/zsh/vendor-completions",
+	"rpm": "/usr/share/zsh/site-functions",
+	"apk": "/usr/share/zsh/site-functions",
+}
+
+// nfpmConfigFor describes the package of job j in format.
+func nfpmConfigFor(cfg *Config, j buildJob, format string) (nfpmConfig, error) {
+	p := cfg.Packages
+	name := binaryName(j.Cfg)
+	abs := func(f string) (string, error) {
+		a, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(a); err != nil {
+			return "", fmt.Errorf("packages: %w", err)
+		}
+		return a, nil
+	}
+	nc := nfpmConfig{
+		Name: packageName(cfg, j), Arch: nfpmArch(j.Target), Platform: "linux", Version: packageVersion(cfg),
+		Maintainer: p.Maintainer, Description: p.Description, Homepage: p.Homepage, License: p.License,
+		Depends: p.Depends,
+	}
+	add := func(src, dst, typ string) error {
+		a, err := abs(src)
+		if err != nil {
+			return err
+		}
+		nc.Contents = append(nc.Contents, nfpmContent{Src: a, Dst: dst, Type: typ})
+		return nil
+	}
+	bin, err := filepath.Abs(j.Out)
+	if err != nil {
+		return nc, err
+	}
+	nc.Contents = append(nc.Contents, nfpmContent{Src: bin, Dst: path.Join(firstNonEmpty(p.BinDir, "/usr/bin"), name)})
+	for _, unit := range p.Systemd {
+		if err := add(unit, "/usr/lib/systemd/system/"+filepath.Base(unit), ""); err != nil {
+			return nc, err
+		}
+	}
+	for src, dst := range p.Configs {
+		if err := add(src, dst, "config|noreplace"); err != nil {
+			return nc, err
+		}
+	}
+	for _, c := range []struct{ src, dst string }{
+		{p.Completions.Bash, "/usr/share/bash-completion/completions/" + name},
+		{p.Completions.Zsh, zshCompletionDir[format] + "/_" + name},
+		{p.Completions.Fish, "/usr/share/fish/vendor_completions.d/" + name + ".fish"},
+	} {
+		if c.src == "" {
+			continue
+		}
+		if err := add(c.src, c.dst, ""); err != nil {
+			return nc, err
+		}
+	}
+	for key, script := range map[string]string{"postinstall": p.Scripts.PostInstall, "preremove": p.Scripts.PreRemove, "postremove": p.Scripts.PostRemove} {
+		if script == "" {
+			continue
+		}
+		a, err := abs(script)
+		if err != nil {
+			return nc, err
+		}
+		if nc.Scripts == nil {
+			nc.Scripts = map[string]string{}
+		}
+		nc.Scripts[key] = a
+	}
+	return nc, nil
+}
+
+// buildLinuxPackages runs nfpm for every packages.formats entry and linux
+// target, writing name_version_arch.<format> next to the binary.
+func buildLinuxPackages(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	formats := cfg.Packages.Formats
+	for _, f := range formats {
+		if zshCompletionDir[f] == "" {
+			return fmt.Errorf("packages.formats: want deb | rpm | apk, got %q", f)
+		}
+	}
+	jobs = packageJobs(jobs, "linux")
+	if len(formats) == 0 || len(jobs) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: nfpm")
+		for _, j := range jobs {
+			for _, f := range formats {
+				fmt.Fprintf(textOut, "nfpm package --packager %s --target %s\n", f, packageFile(cfg, j, nfpmArch(j.Target), f))
+			}
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("nfpm"); err != nil {
+		return fmt.Errorf("packages: nfpm not found in PATH (go install github.com/goreleaser/nfpm/v2/cmd/nfpm@latest)")
+	}
+
+	fmt.Fprintln(textOut, ">>> Linux packages")
+	seen := map[string]string{}
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		for _, f := range formats {
+			out := packageFile(cfg, j, nfpmArch(j.Target), f)
+			if other, ok := seen[out]; ok {
+				return fmt.Errorf("packages: %s and %s both package to %s; leave packages.name unset for several binaries", other, j.label(), out)
+			}
+			seen[out] = j.label()
+			nc, err := nfpmConfigFor(cfg, j, f)
+			if err != nil {
+				return err
+			}
+			if err := runNfpm(nc, f, out); err != nil {
+				return fmt.Errorf("nfpm %s %s: %w", f, j.label(), err)
+			}
+			if err := recordPackage(m, j, f, out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runNfpm writes nc to a temporary config file and builds one package.
+func runNfpm(nc nfpmConfig, format, out string) error {
+	b, err := yaml.Marshal(nc)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", "go-builder-nfpm-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("nfpm", "package", "--config", f.Name(), "--packager", format, "--target", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Job execution: one target at a time or through a worker pool
+   ------------------------------------------------------------------ */
+
+// runJobs builds every job with up to n concurrent workers and records
+// the artifacts of the successful ones in job order. With n > 1 each line
+// of output is prefixed with its target so logs stay readable. Dry runs
+// are always sequential. Unless keepGoing is set, no new build starts
+// after the first failure.
+func runJobs(jobs []buildJob, base map[string]string, m *Manifest, n int, keepGoing, dry bool) error {
+	if n < 1 || dry {
+		n = 1
+	}
+	n = min(n, len(jobs))
+	errs := make([]error, len(jobs))
+	built := make([]bool, len(jobs))
+
+	if n <= 1 {
+		for i, j := range jobs {
+			errs[i] = runJob(j, base, textOut, dry)
+			built[i] = errs[i] == nil
+			if errs[i] != nil && !keepGoing {
+				break
+			}
+		}
+	} else {
+		var (
+			mu     sync.Mutex // serialises writes to stdout
+			wg     sync.WaitGroup
+			failed bool
+		)
+		next := make(chan int)
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					w := &prefixWriter{prefix: "[" + jobs[i].label() + "] ", out: textOut, mu: &mu}
+					errs[i] = runJob(jobs[i], base, w, dry)
+					w.Flush()
+					built[i] = errs[i] == nil
+					if errs[i] != nil {
+						mu.Lock()
+						failed = true
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		for i := range jobs {
+			mu.Lock()
+			stop := failed && !keepGoing
+			mu.Unlock()
+			if stop {
+				break // let running builds finish, start no new ones
+			}
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
+
+	var failed []error
+	for i, j := range jobs {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", j.label(), errs[i]))
+		}
+		if !built[i] || dry {
+			continue
+		}
+		if err := recordArtifact(m, j); err != nil {
+			return err
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if keepGoing {
+		fmt.Fprintf(textOut, "\n✘ %d of %d targets failed:\n", len(failed), len(jobs))
+		for _, err := range failed {
+			fmt.Fprintf(textOut, "  %v\n", err)
+		}
+		return fmt.Errorf("%d of %d targets failed", len(failed), len(jobs))
+	}
+	return errors.Join(failed...)
+}
+
+// recordArtifact adds the built output of j to the manifest.
+func recordArtifact(m *Manifest, j buildJob) error {
+	var cover *ArtifactCover
+	b := j.Cfg.Build
+	if c := j.Target.compiler(b.Compiler); b.Cover && (c == "" || c == "go") {
+		cover = &ArtifactCover{GoCoverDir: b.CoverDir, Packages: b.CoverPkg}
+	}
+	a, err := m.addArtifact(j.Target.label(j.Cfg), j.Out, cover)
+	if err != nil {
+		return err
+	}
+	emit(event{Event: "artifact", Target: j.label(), Artifact: a.Path, Size: a.Size, SHA256: a.SHA256})
+	return nil
+}
+
+// runJob builds, checks and fixes permissions of a single job.
+func runJob(j buildJob, base map[string]string, w io.Writer, dry bool) (err error) {
+	if !j.Host {
+		fmt.Fprintf(w, ">>> Building %s → %s\n", j.label(), j.Out)
+	}
+	start := time.Now()
+	emit(event{Event: "start", Target: j.label(), Artifact: j.Out})
+	defer func() { emit(resultEvent(j.label(), start, err)) }()
+	if err := runBuild(j.Cfg, j.Target, base, envSlice(j.Env), j.Out, w, dry); err != nil {
+		return err
+	}
+	if j.Cfg.Build.StripObjcopy && !dry {
+		if err := stripELF(j.Out, j.Env); err != nil {
+			return err
+		}
+	}
+	if expectsNoCgo(j) && !dry && j.Target.compiler(j.Cfg.Build.Compiler) != "tinygo" {
+		if err := assertNoCgo(j, base); err != nil {
+			return err
+		}
+	}
+	if j.WantStatic {
+		if err := assertStatic(j.Out, w, dry); err != nil {
+			return err
+		}
+	}
+	b := j.Target.build(j.Cfg.Build)
+	if len(b.VerifyDynamicLibs) > 0 && b.BuildMode != "c-archive" {
+		if err := assertLibs(j.Out, b.VerifyDynamicLibs, w, dry); err != nil {
+			return err
+		}
+	}
+	if b.VerifyStripped && j.Target.Arch != "wasm" && b.BuildMode != "c-archive" {
+		if err := assertStripped(j.Out, w, dry); err != nil {
+			return err
+		}
+	}
+	if b.MaxGlibc != "" {
+		if err := assertGlibc(j.Out, b.MaxGlibc, w, dry); err != nil {
+			return err
+		}
+	}
+	if j.Cfg.Build.VerifyArch && j.Target.OS != "" && j.Target.Arch != "wasm" && j.Cfg.Build.BuildMode != "c-archive" {
+		if err := assertArch(j.Out, j.Target.OS, j.Target.Arch, w, dry); err != nil {
+			return err
+		}
+	}
+	smoke := smokeCommand(b.SmokeTest)
+	if dry {
+		if smoke != "" {
+			return runSmokeTest(smoke, j, w, dry)
+		}
+		return nil
+	}
+	if j.Target.WasmExec && j.Target.OS == "js" {
+		if err := copyWasmExec(j.Out, envSlice(j.Env)); err != nil {
+			return err
+		}
+	}
+	if err := applyOutputPerms(j.Cfg.Output, j.Out); err != nil {
+		return err
+	}
+	if j.Cfg.Build.Reproducible {
+		t := sourceDate()
+		if err := os.Chtimes(j.Out, t, t); err != nil {
+			return err
+		}
+	}
+	if smoke != "" {
+		return runSmokeTest(smoke, j, w, dry)
+	}
+	return nil
+}
+
+// stderrFor keeps stderr separate unless output is being prefixed.
+func stderrFor(w io.Writer) io.Writer {
+	if w == textOut {
+		return os.Stderr
+	}
+	return w
+}
+
+// prefixWriter writes complete lines to out, each preceded by prefix.
+type prefixWriter struct {
+	prefix string
+	out    io.Writer
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	p.emit(p.buf[:i+1])
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	return len(b), nil
+}
+
+// Flush writes a trailing partial line.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.emit(append(p.buf, '\n'))
+		p.buf = p.buf[:0]
+	}
+}
+
+func (p *prefixWriter) emit(lines []byte) {
+	var out bytes.Buffer
+	for _, l := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(l) > 0 {
+			out.WriteString(p.prefix)
+			out.Write(l)
+		}
+	}
+	p.mu.Lock()
+	p.out.Write(out.Bytes())
+	p.mu.Unlock()
+}
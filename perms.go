@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Output permissions: output.mode / output.owner / output.umask
+   ------------------------------------------------------------------ */
+
+// applyOutputPerms sets the configured mode and owner on path.
+func applyOutputPerms(o OutputSpec, path string) error {
+	if o.Mode != "" {
+		mode, err := strconv.ParseUint(o.Mode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("output.mode %q: want an octal mode like 0755", o.Mode)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+	if o.Owner != "" {
+		uid, gid, err := lookupOwner(o.Owner)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("output.owner: %w", err)
+		}
+	}
+	return nil
+}
+
+// lookupOwner parses "uid:gid" or "user:group"; a missing group keeps
+// the current one (-1).
+func lookupOwner(s string) (uid, gid int, err error) {
+	u, g, _ := strings.Cut(s, ":")
+	uid, gid = -1, -1
+	if u != "" {
+		if uid, err = strconv.Atoi(u); err != nil {
+			usr, lerr := user.Lookup(u)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("output.owner: %w", lerr)
+			}
+			uid, _ = strconv.Atoi(usr.Uid)
+		}
+	}
+	if g != "" {
+		if gid, err = strconv.Atoi(g); err != nil {
+			grp, lerr := user.LookupGroup(g)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("output.owner: %w", lerr)
+			}
+			gid, _ = strconv.Atoi(grp.Gid)
+		}
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+/* ------------------------------------------------------------------
+   Build plan: resolve every target into a concrete job before running
+   ------------------------------------------------------------------ */
+
+// buildJob is one planned compiler invocation.
+type buildJob struct {
+	Cfg        *Config // the job's binary
+	Target     Target
+	Host       bool              // no targets configured: build for the host
+	Env        map[string]string // base <- global <- target, plus GOOS/GOARCH
+	Out        string
+	WantStatic bool
+}
+
+// planJobs resolves env layers and output paths for every target, or for
+// the host when the config has no targets.
+func planJobs(cfg *Config, baseEnv, globalEnv map[string]string, dry bool) ([]buildJob, error) {
+	baseName := binaryName(cfg)
+	outputFor := func(t Target) (string, error) {
+		switch {
+		case isTemplate(t.Output):
+			return renderOutput(t.Output, cfg, t, baseName)
+		case t.Output != "":
+			return t.Output, nil
+		case isTemplate(cfg.Output.Name):
+			out, err := renderOutput(cfg.Output.Name, cfg, t, baseName)
+			return filepath.Join(cfg.BuildDir, out), err
+		}
+		return defaultOutput(cfg, t, baseName), nil
+	}
+
+	if len(cfg.Targets) == 0 {
+		host := Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+		if cfg.Build.Compiler == "tinygo" && cfg.Build.TinyGo.Target != "" {
+			host = Target{} // board build: GOOS/GOARCH come from -target
+		}
+		out, err := outputFor(host)
+		if err != nil {
+			return nil, err
+		}
+		return []buildJob{{
+			Cfg:        cfg,
+			Target:     host,
+			Host:       true,
+			Env:        mergeEnvLayers(baseEnv, globalEnv, nil),
+			Out:        out,
+			WantStatic: cfg.Build.VerifyStatic && !libraryMode(cfg.Build.BuildMode),
+		}}, nil
+	}
+
+	jobs := make([]buildJob, 0, len(cfg.Targets))
+	seen := map[string]string{}
+	for _, t := range cfg.Targets {
+		targetEnv, err := t.Env.resolve(dry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.label(cfg), err)
+		}
+		env := mergeEnvLayers(baseEnv, globalEnv, targetEnv)
+		if t.OS != "" {
+			env["GOOS"] = t.OS
+		}
+		if t.Arch != "" {
+			env["GOARCH"] = t.Arch
+		}
+		if t.WasmExec && t.OS != "js" {
+			return nil, fmt.Errorf("%s: wasm_exec only applies to js/wasm", t.label(cfg))
+		}
+		name, value, err := t.variantVar()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", t.label(cfg), err)
+		}
+		if name != "" {
+			env[name] = value
+		}
+		out, err := outputFor(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: output: %w", t.label(cfg), err)
+		}
+		if prev, dup := seen[out]; dup {
+			return nil, fmt.Errorf("%s and %s both write %s", prev, t.label(cfg), out)
+		}
+		seen[out] = t.label(cfg)
+		jobs = append(jobs, buildJob{
+			Cfg:        cfg,
+			Target:     t,
+			Env:        env,
+			Out:        out,
+			WantStatic: t.wantStatic(cfg.Build.VerifyStatic) && !libraryMode(cfg.Build.BuildMode),
+		})
+	}
+	return jobs, nil
+}
+
+// binaryName is the name of cfg's binary: output.name unless it is a
+// template, else the binaries entry name or the source's base name.
+func binaryName(cfg *Config) string {
+	if n := cfg.Output.Name; n != "" && !isTemplate(n) {
+		return n
+	}
+	return firstNonEmpty(cfg.binary, filepath.Base(cfg.Source))
+}
+
+// pickBinary is the binary a single-binary step (a formula, an image)
+// uses: want, else the only binary of jobs. key names the setting.
+func pickBinary(jobs []buildJob, key, want string) (string, error) {
+	var names []string
+	for _, j := range jobs {
+		if n := binaryName(j.Cfg); !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	if want != "" {
+		if !slices.Contains(names, want) {
+			return "", fmt.Errorf("%s: no binary %q (have %s)", key, want, strings.Join(names, ", "))
+		}
+		return want, nil
+	}
+	if len(names) != 1 {
+		return "", fmt.Errorf("%s: set one of %s", key, strings.Join(names, ", "))
+	}
+	return names[0], nil
+}
+
+// outputVars are the fields available in output templates.
+type outputVars struct {
+	Name, Version, OS, Arch, Variant, Ext string
+}
+
+func isTemplate(s string) bool { return strings.Contains(s, "{{") }
+
+// renderOutput executes an output template such as
+// {{.Name}}_{{.Version}}_{{.OS}}_{{.Arch}}{{.Ext}} for target t.
+func renderOutput(tmpl string, cfg *Config, t Target, name string) (string, error) {
+	tp, err := template.New("output").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = tp.Execute(&b, outputVars{
+		Name:    name,
+		Version: firstNonEmpty(cfg.Version, "dev"),
+		OS:      t.OS,
+		Arch:    t.Arch,
+		Variant: t.variantDir(),
+		Ext:     outputExt(cfg, t),
+	})
+	return filepath.FromSlash(b.String()), err
+}
+
+// label names the job in logs: os/arch, preceded by the binary name
+// when the config has a binaries list.
+func (j buildJob) label() string { return jobLabel(j.Cfg, j.Target) }
+
+func jobLabel(cfg *Config, t Target) string {
+	if cfg.binary != "" {
+		return cfg.binary + " " + t.label(cfg)
+	}
+	return t.label(cfg)
+}
+
+// selectJobs keeps the jobs whose label (os/arch[/variant]) matches one of the
+// path.Match patterns; every pattern must match at least one job.
+func selectJobs(jobs []buildJob, patterns []string) ([]buildJob, error) {
+	if len(patterns) == 0 {
+		return jobs, nil
+	}
+	var out []buildJob
+	used := make([]bool, len(patterns))
+	for _, j := range jobs {
+		keep := false
+		for i, p := range patterns {
+			ok, err := path.Match(p, j.Target.label(j.Cfg))
+			if err != nil {
+				return nil, fmt.Errorf("--target %q: %w", p, err)
+			}
+			if !ok && j.Target.Variant != "" { // linux/* also selects linux/arm/v7
+				ok, _ = path.Match(p, j.Target.OS+"/"+j.Target.Arch)
+			}
+			if ok {
+				keep, used[i] = true, true
+			}
+		}
+		if keep {
+			out = append(out, j)
+		}
+	}
+	for i, p := range patterns {
+		if !used[i] {
+			return nil, fmt.Errorf("--target %q matches no configured target", p)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   CGO preflight: fail fast on a broken C toolchain before the matrix
+   ------------------------------------------------------------------ */
+
+// cgoPreflight checks, for every job with CGO_ENABLED=1, that its CC
+// exists and can compile and link a trivial program. Each distinct
+// CC/GOOS/GOARCH combination is probed once.
+func cgoPreflight(jobs []buildJob) error {
+	tmp, err := os.MkdirTemp("", "go-builder-preflight-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "main.c")
+	if err := os.WriteFile(src, []byte("int main(void) { return 0; }\n"), 0o644); err != nil {
+		return err
+	}
+
+	probed := map[string]error{}
+	var failed []string
+	for _, j := range jobs {
+		if j.Env["CGO_ENABLED"] != "1" || j.Target.compiler(j.Cfg.Build.Compiler) == "tinygo" {
+			continue
+		}
+		cc := firstNonEmpty(j.Env["CC"], "gcc")
+		key := cc + "|" + j.Env["GOOS"] + "/" + j.Env["GOARCH"]
+		err, done := probed[key]
+		if !done {
+			err = probeCC(cc, src, filepath.Join(tmp, fmt.Sprintf("a%d.out", len(probed))), j.Env)
+			probed[key] = err
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("  %s: CC=%q: %v", j.label(), cc, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("CGO preflight failed (use --skip-preflight to bypass):\n%s", strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// probeCC compiles src with cc (which may carry flags, e.g. "zig cc -target …").
+func probeCC(cc, src, out string, env map[string]string) error {
+	argv := strings.Fields(cc)
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return fmt.Errorf("%s not found in PATH", argv[0])
+	}
+	cmd := exec.Command(argv[0], append(argv[1:], src, "-o", out)...)
+	cmd.Env = envSlice(env)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cannot compile a trivial program: %s", strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+/* ------------------------------------------------------------------
+   Docker preflight: engine, platform and file sharing before a run
+   ------------------------------------------------------------------ */
+
+// engineArch maps `docker info` architecture names to GOARCH.
+var engineArch = map[string]string{
+	"x86_64": "amd64", "aarch64": "arm64", "armv7l": "arm", "armv6l": "arm",
+	"i386": "386", "i686": "386", "ppc64le": "ppc64le", "s390x": "s390x", "riscv64": "riscv64",
+}
+
+// engineInfo is the engine's container OS, GOARCH and operating system
+// description (e.g. "Docker Desktop").
+func engineInfo(rt containerRuntime) (osType, arch, desc string, err error) {
+	format := "{{.OSType}}|{{.Architecture}}|{{.OperatingSystem}}"
+	if rt.Name() == "podman" {
+		format = "{{.Host.OS}}|{{.Host.Arch}}|{{.Host.Distribution.Distribution}}"
+	}
+	out, err := exec.Command(rt.Bin(), "info", "--format", format).Output()
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s info: %w", rt.Bin(), err)
+	}
+	f := strings.SplitN(strings.TrimSpace(string(out)), "|", 3)
+	if len(f) < 3 {
+		return "", "", "", fmt.Errorf("%s info: unexpected output %q", rt.Bin(), out)
+	}
+	return f[0], firstNonEmpty(engineArch[f[1]], f[1]), f[2], nil
+}
+
+// dockerPreflight checks what would otherwise fail inside `docker run`
+// with the runtime's raw error: the engine runs Linux containers, foreign
+// platforms can be emulated and Docker Desktop shares the project.
+// sections are the docker settings of every container to run.
+func dockerPreflight(rt containerRuntime, sections []*DockerSection) error {
+	osType, arch, desc, err := engineInfo(rt)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	if osType != "linux" {
+		failed = append(failed, fmt.Sprintf("  the engine runs %s containers; switch it to Linux containers", osType))
+	}
+	// the engine's kernel is ours only for a local engine on Linux
+	local := runtime.GOOS == "linux" && !sections[0].remote
+	for _, c := range sections {
+		want := arch
+		if c.Platform != "" {
+			_, want, _ = strings.Cut(c.Platform, "/")
+			want, _, _ = strings.Cut(want, "/")
+		}
+		if want == arch || !local || c.SetupQemu || qemuArch[want] == "" {
+			continue
+		}
+		if _, err := os.Stat("/proc/sys/fs/binfmt_misc/qemu-" + qemuArch[want]); err != nil {
+			failed = append(failed, fmt.Sprintf("  %s: linux/%s needs emulation on this linux/%s host and no QEMU handler is registered; set docker.setup_qemu: true",
+				dockerImage(c), want, arch))
+		}
+	}
+	if runtime.GOOS == "darwin" && strings.Contains(desc, "Docker Desktop") && !sections[0].remote {
+		if err := desktopShared(); err != nil {
+			failed = append(failed, "  "+err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("docker preflight failed (use --skip-preflight to bypass):\n%s", strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+// desktopShared checks that Docker Desktop for Mac shares the project
+// directory; otherwise the bind mount is refused ("Mounts denied").
+func desktopShared() error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if r, err := filepath.EvalSymlinks(cwd); err == nil {
+		cwd = r // /tmp → /private/tmp
+	}
+	shared := []string{"/Users", "/Volumes", "/private", "/tmp", "/var/folders"}
+	home, _ := os.UserHomeDir()
+	for _, name := range []string{"settings-store.json", "settings.json"} {
+		b, err := os.ReadFile(filepath.Join(home, "Library/Group Containers/group.com.docker", name))
+		if err != nil {
+			continue
+		}
+		var s struct {
+			Old []string `json:"filesharingDirectories"`
+			New []string `json:"FilesharingDirectories"`
+		}
+		if json.Unmarshal(b, &s) == nil && len(s.Old)+len(s.New) > 0 {
+			shared = append(s.New, s.Old...)
+			break
+		}
+	}
+	for _, dir := range shared {
+		if cwd == dir || strings.HasPrefix(cwd, strings.TrimSuffix(dir, "/")+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not shared with Docker Desktop (shared: %s); add it under Settings → Resources → File sharing",
+		cwd, strings.Join(shared, ", "))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"debug/buildinfo"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   SLSA v1 provenance: one in-toto statement (DSSE envelope) per artifact
+   ------------------------------------------------------------------ */
+
+const (
+	provenanceExt    = ".intoto.jsonl"
+	provenanceType   = "https://slsa.dev/provenance/v1"
+	inTotoPayload    = "application/vnd.in-toto+json"
+	goBuilderRepo    = "https://github.com/pablolagos/go-builder"
+	goBuilderBuildID = goBuilderRepo + "/buildtypes/go-builder@v1"
+)
+
+// startedOn is when this go-builder run began.
+var startedOn = time.Now().UTC()
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type inTotoStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   map[string]any       `json:"externalParameters"`
+		InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+		ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  time.Time `json:"startedOn"`
+			FinishedOn time.Time `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// writeProvenance writes <artifact>.intoto.jsonl next to every artifact
+// built in this run, signed when provenance.key is set.
+func writeProvenance(cfg *Config, cfgPath string, jobs []buildJob, m *Manifest, dry bool) error {
+	if dry {
+		for _, j := range jobs {
+			fmt.Fprintf(textOut, "# Dry-run: provenance %s\n", j.Out+provenanceExt)
+		}
+		return nil
+	}
+	var signer crypto.Signer
+	if cfg.Provenance.Key != "" {
+		s, err := loadSigner(cfg.Provenance.Key)
+		if err != nil {
+			return fmt.Errorf("provenance.key: %w", err)
+		}
+		signer = s
+	}
+	cfgSum, _, err := fileSHA256(cfgPath)
+	if err != nil {
+		return err
+	}
+	deps, err := provenanceDeps()
+	if err != nil {
+		return err
+	}
+
+	for _, j := range jobs {
+		a := findArtifact(m, j.Out)
+		if a == nil {
+			continue // not built (--keep-going)
+		}
+		var st inTotoStatement
+		st.Type = "https://in-toto.io/Statement/v1"
+		st.PredicateType = provenanceType
+		st.Subject = []resourceDescriptor{{Name: a.Path, Digest: map[string]string{"sha256": a.SHA256}}}
+		p := &st.Predicate
+		p.BuildDefinition.BuildType = goBuilderBuildID
+		p.BuildDefinition.ExternalParameters = map[string]any{
+			"config": resourceDescriptor{URI: cfgPath, Digest: map[string]string{"sha256": cfgSum}},
+			"target": j.Target.label(j.Cfg),
+		}
+		internal := map[string]any{}
+		for k, v := range map[string]string{"GOOS": j.Target.OS, "GOARCH": j.Target.Arch, "CGO_ENABLED": j.Env["CGO_ENABLED"]} {
+			if v != "" {
+				internal[k] = v
+			}
+		}
+		p.BuildDefinition.InternalParameters = internal
+		p.BuildDefinition.ResolvedDependencies = deps
+		p.RunDetails.Builder.ID = goBuilderRepo + "@" + builderVersion()
+		p.RunDetails.Builder.Version = map[string]string{}
+		if bi, err := buildinfo.ReadFile(j.Out); err == nil {
+			p.RunDetails.Builder.Version["go"] = bi.GoVersion // toolchain that built the artifact
+		}
+		if m.Builder != nil {
+			p.RunDetails.Builder.Version["image"] = m.Builder.Digest
+		}
+		p.RunDetails.Metadata.StartedOn = startedOn
+		p.RunDetails.Metadata.FinishedOn = time.Now().UTC()
+
+		payload, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		env := dsseEnvelope{PayloadType: inTotoPayload, Payload: base64.StdEncoding.EncodeToString(payload), Signatures: []dsseSignature{}}
+		if signer != nil {
+			sig, err := dsseSign(signer, payload)
+			if err != nil {
+				return fmt.Errorf("provenance: sign: %w", err)
+			}
+			env.Signatures = append(env.Signatures, dsseSignature{Sig: base64.StdEncoding.EncodeToString(sig)})
+		}
+		b, err := json.Marshal(env)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(j.Out+provenanceExt, append(b, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(textOut, "✔ provenance %s\n", j.Out+provenanceExt)
+	}
+	return nil
+}
+
+func findArtifact(m *Manifest, path string) *ManifestArtifact {
+	for i := range m.Artifacts {
+		if m.Artifacts[i].Path == filepath.ToSlash(path) {
+			return &m.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+// provenanceDeps lists the source commit and every module in the build
+// list as resolved dependencies.
+func provenanceDeps() ([]resourceDescriptor, error) {
+	var deps []resourceDescriptor
+	if commit := currentMeta().GitCommit; commit != "" {
+		uri := git("remote", "get-url", "origin")
+		if uri == "" {
+			uri = "."
+		}
+		deps = append(deps, resourceDescriptor{URI: "git+" + uri, Digest: map[string]string{"gitCommit": commit}})
+	}
+	mods, err := listModules()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mods {
+		if m.Main {
+			continue
+		}
+		d := resourceDescriptor{URI: "pkg:golang/" + m.Path + "@" + m.Version}
+		if sum, ok := strings.CutPrefix(m.Sum, "h1:"); ok {
+			if raw, err := base64.StdEncoding.DecodeString(sum); err == nil {
+				d.Digest = map[string]string{"sha256": hex.EncodeToString(raw)} // go.sum dirhash
+			}
+		}
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// builderVersion is the module version go-builder was installed at.
+func builderVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" {
+		return bi.Main.Version
+	}
+	return "(devel)"
+}
+
+// loadSigner reads an unencrypted PKCS#8, EC or PKCS#1 private key in PEM.
+func loadSigner(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, errors.New(path + ": no PEM block")
+	}
+	var key any
+	switch blk.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(blk.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w (encrypted keys are not supported)", path, err)
+	}
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, key)
+	}
+	return s, nil
+}
+
+// dsseSign signs the DSSE pre-authentication encoding of payload.
+func dsseSign(s crypto.Signer, payload []byte) ([]byte, error) {
+	pae := fmt.Sprintf("DSSEv1 %d %s %d %s", len(inTotoPayload), inTotoPayload, len(payload), payload)
+	switch s.(type) {
+	case ed25519.PrivateKey:
+		return s.Sign(rand.Reader, []byte(pae), crypto.Hash(0))
+	case *ecdsa.PrivateKey, *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(pae))
+		return s.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	return nil, fmt.Errorf("unsupported key type %T", s)
+}
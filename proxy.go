@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Local module proxy: `proxy warm` fills a GOMODCACHE whose download
+   tree is a valid GOPROXY; `proxy serve` exposes it over HTTP
+   ------------------------------------------------------------------ */
+
+// proxyContainerDir is where the proxy cache is mounted in containers.
+const proxyContainerDir = "/go-builder-proxy"
+
+// proxyDir is proxy.dir or the shared user cache.
+func proxyDir(cfg *Config) string {
+	if cfg.Proxy != nil && cfg.Proxy.Dir != "" {
+		return cfg.Proxy.Dir
+	}
+	return cacheDir("modproxy")
+}
+
+// proxyURL is the file:// GOPROXY for a proxy cache rooted at dir.
+func proxyURL(dir string) string {
+	p := filepath.ToSlash(filepath.Join(dir, "cache", "download"))
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p // C:/… → /C:/…
+	}
+	return "file://" + p
+}
+
+// proxyEnabled reports whether builds should use the local proxy.
+func proxyEnabled(cfg *Config) bool {
+	return cfg.Proxy != nil && cfg.Proxy.Use
+}
+
+func proxyCmd(cfg *Config, args []string, dry bool) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: go-builder proxy warm | serve")
+	}
+	dir, err := filepath.Abs(proxyDir(cfg))
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "warm":
+		// `all` covers every module in the build list, test deps included.
+		env := append(os.Environ(), "GOMODCACHE="+dir, "GOFLAGS=-mod=mod")
+		if cfg.GoVersion != "" {
+			goroot, err := ensureToolchain(cfg.GoVersion, false, dry)
+			if err != nil {
+				return err
+			}
+			goBin = filepath.Join(goroot, "bin", exeName("go"))
+			env = append(env, "GOTOOLCHAIN=local")
+		}
+		cmd := exec.Command(goBin, "mod", "download", "-x", "all")
+		cmd.Env = env
+		if dry {
+			fmt.Printf("# Dry-run: GOMODCACHE=%s %s %s\n", dir, goBin, strings.Join(cmd.Args[1:], " "))
+			return nil
+		}
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("proxy warm: %w", err)
+		}
+		fmt.Printf("module proxy warmed: %s (%s)\nuse it with GOPROXY=%s\n", dir, humanSize(dirSize(dir)), proxyURL(dir))
+		return nil
+	case "serve":
+		addr := "127.0.0.1:3000"
+		if cfg.Proxy != nil && cfg.Proxy.Addr != "" {
+			addr = cfg.Proxy.Addr
+		}
+		root := filepath.Join(dir, "cache", "download")
+		if _, err := os.Stat(root); err != nil {
+			return fmt.Errorf("no module cache at %s, run `go-builder proxy warm` first", dir)
+		}
+		fmt.Printf("serving %s on http://%s (GOPROXY=http://%s)\n", root, addr, addr)
+		if dry {
+			return nil
+		}
+		return http.ListenAndServe(addr, http.FileServer(http.Dir(root)))
+	}
+	return fmt.Errorf("proxy: unknown action %q (want warm | serve)", args[0])
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Transparency log: signatures and attestations in Rekor
+   ------------------------------------------------------------------ */
+
+const defaultRekor = "https://rekor.sigstore.dev"
+
+func (r *RekorLog) url() string {
+	return firstNonEmpty(r.URL, defaultRekor)
+}
+
+// bundleLogIndex reads the log index from a cosign --bundle file, in
+// either the legacy or the protobuf (sigstore bundle) layout.
+func bundleLogIndex(file string) (int64, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	var bundle struct {
+		RekorBundle *struct {
+			Payload struct {
+				LogIndex int64 `json:"logIndex"`
+			} `json:"Payload"`
+		} `json:"rekorBundle"`
+		VerificationMaterial *struct {
+			TlogEntries []struct {
+				LogIndex string `json:"logIndex"`
+			} `json:"tlogEntries"`
+		} `json:"verificationMaterial"`
+	}
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		return 0, fmt.Errorf("%s: %w", file, err)
+	}
+	switch {
+	case bundle.RekorBundle != nil:
+		return bundle.RekorBundle.Payload.LogIndex, nil
+	case bundle.VerificationMaterial != nil && len(bundle.VerificationMaterial.TlogEntries) > 0:
+		return strconv.ParseInt(bundle.VerificationMaterial.TlogEntries[0].LogIndex, 10, 64)
+	}
+	return 0, fmt.Errorf("%s: no transparency log entry", file)
+}
+
+// rekorEntryURL is where a log entry can be fetched by index.
+func rekorEntryURL(r *RekorLog, index int64) string {
+	return fmt.Sprintf("%s/api/v1/log/entries?logIndex=%d", strings.TrimSuffix(r.url(), "/"), index)
+}
+
+// runRekor uploads the gpg signatures and signed provenance of this run
+// with rekor-cli and records the entries in the manifest. Cosign does its
+// own upload (see cosignArgs).
+func runRekor(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	r := cfg.Sign.Rekor
+	type upload struct {
+		kind, file string
+		args       []string
+	}
+	var uploads []upload
+
+	if g := cfg.Sign.GPG; g != nil {
+		files, err := signFiles(cfg, jobs, "gpg", g.Artifacts, "checksums")
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			uploads = append(uploads, upload{"gpg", f, []string{"--pki-format", "pgp",
+				"--artifact", f, "--signature", f + ".asc", "--public-key", "{gpg public key}"}})
+		}
+	}
+	if p := cfg.Provenance; p != nil {
+		if p.Key == "" {
+			fmt.Fprintln(textOut, "note: sign.rekor: provenance is unsigned, not uploaded (set provenance.key)")
+		} else {
+			for _, j := range jobs {
+				f := j.Out + provenanceExt
+				uploads = append(uploads, upload{"provenance", f, []string{"--type", "intoto", "--pki-format", "x509",
+					"--artifact", f, "--public-key", "{provenance public key}"}})
+			}
+		}
+	}
+	if len(uploads) == 0 {
+		return nil
+	}
+
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: rekor")
+		for _, u := range uploads {
+			fmt.Fprintln(textOut, "rekor-cli upload --rekor_server "+r.url()+" "+strings.Join(u.args, " "))
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("rekor-cli"); err != nil {
+		return fmt.Errorf("sign.rekor: rekor-cli not found in PATH (https://docs.sigstore.dev/logging/installation/)")
+	}
+	keys, err := rekorPublicKeys(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, k := range keys {
+			os.Remove(k)
+		}
+	}()
+
+	fmt.Fprintln(textOut, ">>> Rekor")
+	for _, u := range uploads {
+		if _, err := os.Stat(u.file); err != nil {
+			continue // not built (--keep-going)
+		}
+		args := []string{"upload", "--rekor_server", r.url(), "--format", "json"}
+		for _, a := range u.args {
+			args = append(args, firstNonEmpty(keys[a], a))
+		}
+		e, err := rekorUpload(r, args)
+		if err != nil {
+			return fmt.Errorf("rekor: %s: %w", u.file, err)
+		}
+		e.Path, e.Kind = u.file, u.kind
+		m.addRekor(e)
+		fmt.Fprintf(textOut, "✔ %s logged at index %d\n", u.file, e.LogIndex)
+	}
+	return nil
+}
+
+// rekorPublicKeys writes the public keys uploads are checked against to
+// temp files, keyed by their placeholder in the rekor-cli arguments.
+func rekorPublicKeys(cfg *Config) (map[string]string, error) {
+	keys := map[string]string{}
+	write := func(name string, b []byte) error {
+		f, err := os.CreateTemp("", "go-builder-rekor-*.pub")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		keys[name] = f.Name()
+		_, err = f.Write(b)
+		return err
+	}
+	if g := cfg.Sign.GPG; g != nil {
+		key, err := gpgSigningKey(g)
+		if err != nil {
+			return keys, err
+		}
+		pub, err := exec.Command("gpg", "--armor", "--export", key).Output()
+		if err != nil || len(pub) == 0 {
+			return keys, fmt.Errorf("gpg --export %s: no public key (%v)", key, err)
+		}
+		if err := write("{gpg public key}", pub); err != nil {
+			return keys, err
+		}
+	}
+	if p := cfg.Provenance; p != nil && p.Key != "" {
+		s, err := loadSigner(p.Key)
+		if err != nil {
+			return keys, fmt.Errorf("provenance.key: %w", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(s.Public())
+		if err != nil {
+			return keys, err
+		}
+		if err := write("{provenance public key}", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// gpgSigningKey is the fingerprint of the key runGPG signs with.
+func gpgSigningKey(g *GPGSign) (string, error) {
+	args := []string{"--list-secret-keys", "--with-colons"}
+	if key := firstNonEmpty(g.Key, os.Getenv("GPG_KEY_ID")); key != "" {
+		args = append(args, key)
+	}
+	out, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("gpg --list-secret-keys: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if f := strings.Split(line, ":"); f[0] == "fpr" && len(f) > 9 {
+			return f[9], nil
+		}
+	}
+	return "", fmt.Errorf("gpg: no secret key found")
+}
+
+// rekorUpload runs `rekor-cli upload`; an entry that is already in the
+// log is looked up so its index can still be recorded.
+func rekorUpload(r *RekorLog, args []string) (RekorEntry, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("rekor-cli", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return RekorEntry{}, fmt.Errorf("rekor-cli upload: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var res struct {
+		AlreadyExists bool
+		Location      string
+		Index         int64
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return RekorEntry{}, fmt.Errorf("rekor-cli upload: %w", err)
+	}
+	if res.AlreadyExists {
+		uuid := path.Base(res.Location)
+		out, err := exec.Command("rekor-cli", "get", "--rekor_server", r.url(), "--uuid", uuid, "--format", "json").Output()
+		if err != nil {
+			return RekorEntry{}, fmt.Errorf("rekor-cli get %s: %w", uuid, err)
+		}
+		var got struct{ LogIndex int64 }
+		if err := json.Unmarshal(out, &got); err != nil {
+			return RekorEntry{}, fmt.Errorf("rekor-cli get: %w", err)
+		}
+		res.Index = got.LogIndex
+	}
+	return RekorEntry{LogIndex: res.Index, URL: res.Location}, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Timeouts and retries for long-running external commands
+   ------------------------------------------------------------------ */
+
+// parseTimeout reads a duration such as "10m"; "" means no limit.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("timeout: want a duration like 90s or 10m, got %q", s)
+	}
+	return d, nil
+}
+
+// runRetry runs the command built by newCmd, killing it after timeout
+// (0 = none) and trying again up to retries more times on failure.
+func runRetry(newCmd func(ctx context.Context) *exec.Cmd, timeout time.Duration, retries int, w io.Writer) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(w, "↻ retry %d/%d after: %v\n", attempt, retries, err)
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		cmd := newCmd(ctx)
+		cmd.WaitDelay = 5 * time.Second // don't hang on children holding the pipes
+		err = cmd.Run()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
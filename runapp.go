@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   `go-builder run`: build the host target and execute it
+   ------------------------------------------------------------------ */
+
+// appRunner runs the built binary and restarts it after each rebuild.
+type appRunner struct {
+	mu   sync.Mutex
+	path string
+	args []string
+	env  []string
+	cmd  *exec.Cmd
+	done chan error
+}
+
+// start launches the binary; a previous instance is stopped first, but
+// with --watch it already was, before the rebuild replaced the binary.
+func (r *appRunner) start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopLocked()
+	fmt.Fprintf(textOut, ">>> Running %s\n", r.path)
+	cmd := exec.Command(r.path, r.args...)
+	cmd.Env = r.env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, textOut, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	r.cmd, r.done = cmd, make(chan error, 1)
+	go func() { r.done <- cmd.Wait() }()
+	return nil
+}
+
+// stop interrupts the running instance and kills it if it has not
+// exited after a few seconds.
+func (r *appRunner) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopLocked()
+}
+
+func (r *appRunner) stopLocked() {
+	if r.cmd == nil {
+		return
+	}
+	if runtime.GOOS == "windows" || r.cmd.Process.Signal(os.Interrupt) != nil {
+		r.cmd.Process.Kill()
+	}
+	select {
+	case <-r.done:
+	case <-time.After(3 * time.Second):
+		r.cmd.Process.Kill()
+		<-r.done
+	}
+	r.cmd = nil
+}
+
+// wait blocks until the binary exits and returns its exit code.
+func (r *appRunner) wait() int {
+	err := <-r.done
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.ExitCode()
+	}
+	fmt.Fprintf(os.Stderr, "go-builder: %v\n", err)
+	return 1
+}
+
+// runCmd builds the single host job and runs it with args; with watch it
+// keeps rebuilding and restarting instead of returning.
+func runCmd(cfg *Config, jobs []buildJob, base map[string]string, m *Manifest, args []string, watching, dry bool) (int, error) {
+	hosts := hostJobs(jobs)
+	if len(hosts) != 1 {
+		return 1, fmt.Errorf("run: want exactly one target for the host (%s/%s), have %d", runtime.GOOS, runtime.GOARCH, len(hosts))
+	}
+	j := hosts[0]
+	r := &appRunner{path: j.Out, args: args, env: envSlice(j.Env)}
+	// take the binary down with us on Ctrl-C / SIGTERM
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		r.stop()
+		os.Exit(130)
+	}()
+
+	if dry {
+		if err := runJobs(hosts, base, m, 1, false, true); err != nil {
+			return 1, err
+		}
+		fmt.Fprintf(textOut, "# Dry-run: %s %v\n", r.path, args)
+		return 0, nil
+	}
+	if watching {
+		return 1, watchBuild(cfg, hosts, base, m, false, r)
+	}
+	if err := runJobs(hosts, base, m, 1, false, false); err != nil {
+		return 1, err
+	}
+	if err := m.save(cfg.BuildDir); err != nil {
+		return 1, err
+	}
+	if err := r.start(); err != nil {
+		return 1, err
+	}
+	return r.wait(), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Signing: cosign sign-blob and gpg detached signatures
+   ------------------------------------------------------------------ */
+
+// signFiles lists what sign.<tool>.artifacts selects (def when unset):
+// the artifacts of this run and/or the checksum files.
+func signFiles(cfg *Config, jobs []buildJob, tool, what, def string) ([]string, error) {
+	what = firstNonEmpty(what, def)
+	var files []string
+	switch what {
+	case "all", "binaries":
+		for _, j := range jobs {
+			files = append(files, j.Out)
+		}
+	case "checksums":
+	default:
+		return nil, fmt.Errorf("sign.%s.artifacts: want all | binaries | checksums, got %q", tool, what)
+	}
+	if what != "binaries" && cfg.Checksums != nil {
+		for _, cf := range checksumFiles(cfg) {
+			files = append(files, cf.Path)
+		}
+	}
+	return files, nil
+}
+
+// cosignArgs is the sign-blob command line for one file. Keyless signing
+// also writes the Fulcio certificate next to the signature. Key-based
+// signatures only go to the transparency log with sign.rekor, which also
+// keeps the bundle for the log index.
+func cosignArgs(c *CosignSign, rekor *RekorLog, file string) []string {
+	args := []string{"sign-blob", "--yes", "--output-signature", file + ".sig"}
+	if c.Key != "" {
+		args = append(args, "--key", c.Key)
+		if rekor == nil {
+			args = append(args, "--tlog-upload=false")
+		}
+	} else {
+		args = append(args, "--output-certificate", file+".pem")
+	}
+	if rekor != nil {
+		args = append(args, "--bundle", file+".bundle")
+		if rekor.URL != "" {
+			args = append(args, "--rekor-url", rekor.URL)
+		}
+	}
+	args = append(args, c.Args...)
+	return append(args, file)
+}
+
+// runCosign signs every selected file; the key password comes from
+// COSIGN_PASSWORD as usual.
+func runCosign(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	c, rekor := cfg.Sign.Cosign, cfg.Sign.Rekor
+	files, err := signFiles(cfg, jobs, "cosign", c.Artifacts, "all")
+	if err != nil {
+		return err
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: cosign")
+		for _, f := range files {
+			fmt.Fprintln(textOut, "cosign "+strings.Join(cosignArgs(c, rekor, f), " "))
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("cosign"); err != nil {
+		return fmt.Errorf("sign.cosign: cosign not found in PATH (https://docs.sigstore.dev/cosign/system_config/installation/)")
+	}
+
+	fmt.Fprintln(textOut, ">>> Signing")
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			continue // not built (--keep-going)
+		}
+		cmd := exec.Command("cosign", cosignArgs(c, rekor, f)...)
+		cmd.Stdout = textOut
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("cosign sign-blob %s: %w", filepath.Base(f), err)
+		}
+		fmt.Fprintf(textOut, "✔ signed %s\n", f+".sig")
+		if rekor != nil {
+			idx, err := bundleLogIndex(f + ".bundle")
+			if err != nil {
+				return err
+			}
+			m.addRekor(RekorEntry{Path: f, Kind: "cosign", LogIndex: idx, URL: rekorEntryURL(rekor, idx)})
+			fmt.Fprintf(textOut, "✔ %s logged at index %d\n", f, idx)
+		}
+	}
+	return nil
+}
+
+// gpgArgs is the detached-signature command line for one file.
+func gpgArgs(g *GPGSign, key, file string, passphrase bool) []string {
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", file + ".asc"}
+	if key != "" {
+		args = append(args, "--local-user", key)
+	}
+	if passphrase {
+		args = append(args, "--pinentry-mode", "loopback", "--passphrase-fd", "0")
+	}
+	args = append(args, g.Args...)
+	return append(args, file)
+}
+
+// runGPG signs every selected file with gpg. The key is sign.gpg.key, else
+// $GPG_KEY_ID, else gpg's default; $GPG_PASSPHRASE is fed on stdin when
+// set, otherwise gpg-agent is asked.
+func runGPG(cfg *Config, jobs []buildJob, dry bool) error {
+	g := cfg.Sign.GPG
+	files, err := signFiles(cfg, jobs, "gpg", g.Artifacts, "checksums")
+	if err != nil {
+		return err
+	}
+	key := firstNonEmpty(g.Key, os.Getenv("GPG_KEY_ID"))
+	pass, hasPass := os.LookupEnv("GPG_PASSPHRASE")
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: gpg")
+		for _, f := range files {
+			fmt.Fprintln(textOut, "gpg "+strings.Join(gpgArgs(g, key, f, hasPass), " "))
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("sign.gpg: gpg not found in PATH")
+	}
+
+	fmt.Fprintln(textOut, ">>> GPG signing")
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			continue // not built (--keep-going)
+		}
+		cmd := exec.Command("gpg", gpgArgs(g, key, f, hasPass)...)
+		if hasPass {
+			cmd.Stdin = strings.NewReader(pass + "\n")
+		}
+		cmd.Stdout = textOut
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("gpg --detach-sign %s: %w", filepath.Base(f), err)
+		}
+		fmt.Fprintf(textOut, "✔ signed %s\n", f+".asc")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   `go-builder size-diff REF`: artifact sizes against a previous build
+   ------------------------------------------------------------------ */
+
+// sizeHistoryDir keeps the manifest of every tagged build.
+const sizeHistoryDir = ".sizes"
+
+func sizeHistory(cfg *Config) string {
+	if cfg.SizeDiff != nil && cfg.SizeDiff.History != "" {
+		return cfg.SizeDiff.History
+	}
+	return filepath.Join(cfg.BuildDir, sizeHistoryDir)
+}
+
+// recordSizes stores the manifest under the tag HEAD points at, so later
+// builds can be compared with this release by tag name.
+func recordSizes(cfg *Config, m *Manifest) error {
+	tag := git("describe", "--tags", "--exact-match")
+	if tag == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := sizeHistory(cfg)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, tag+".json"), append(b, '\n'), 0o644)
+}
+
+// sizeDiffCmd compares build_dir/manifest.json with ref: a build dir, a
+// manifest file, or a tag recorded by recordSizes.
+func sizeDiffCmd(cfg *Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: go-builder size-diff <build dir | manifest.json | tag>")
+	}
+	cur, err := loadManifest(cfg.BuildDir)
+	if err != nil {
+		return err
+	}
+	if len(cur.Artifacts) == 0 {
+		return fmt.Errorf("no artifacts in %s; build first", filepath.Join(cfg.BuildDir, manifestName))
+	}
+	prev, err := loadSizeRef(cfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	old := artifactsByKey(prev)
+	var threshold float64
+	if cfg.SizeDiff != nil {
+		threshold = cfg.SizeDiff.Threshold
+	}
+	var grown []string
+	fmt.Printf("%-30s %12s %12s %12s %8s\n", "artifact", args[0], "current", "delta", "")
+	now := artifactsByKey(cur)
+	keys := make([]string, 0, len(now))
+	for k := range now {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		a := now[k]
+		p, ok := old[k]
+		if !ok {
+			fmt.Printf("%-30s %12s %12s %12s %8s\n", k, "-", humanSize(a.Size), "", "new")
+			continue
+		}
+		delta := a.Size - p.Size
+		pct := 100 * float64(delta) / float64(max(p.Size, 1))
+		fmt.Printf("%-30s %12s %12s %12s %+7.1f%%\n", k, humanSize(p.Size), humanSize(a.Size), signedSize(delta), pct)
+		if threshold > 0 && pct > threshold {
+			grown = append(grown, fmt.Sprintf("%s (%+.1f%%)", k, pct))
+		}
+	}
+	for k := range old {
+		if _, ok := now[k]; !ok {
+			fmt.Printf("%-30s %12s %12s %12s %8s\n", k, humanSize(old[k].Size), "-", "", "removed")
+		}
+	}
+	if len(grown) > 0 {
+		return fmt.Errorf("size grew more than %g%%: %s", threshold, strings.Join(grown, ", "))
+	}
+	return nil
+}
+
+func loadSizeRef(cfg *Config, ref string) (*Manifest, error) {
+	if fi, err := os.Stat(ref); err == nil {
+		if fi.IsDir() {
+			ref = filepath.Join(ref, manifestName)
+		}
+		return readManifestFile(ref)
+	}
+	m, err := readManifestFile(filepath.Join(sizeHistory(cfg), ref+".json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: not a path, and no sizes recorded for tag %s in %s", ref, ref, sizeHistory(cfg))
+	}
+	return m, err
+}
+
+func readManifestFile(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// artifactsByKey keys binaries by target, adding the file name when a
+// target has several (multiple binaries). Packages are left out.
+func artifactsByKey(m *Manifest) map[string]ManifestArtifact {
+	count := map[string]int{}
+	for _, a := range m.Artifacts {
+		if a.Kind == "" {
+			count[a.Target]++
+		}
+	}
+	out := map[string]ManifestArtifact{}
+	for _, a := range m.Artifacts {
+		if a.Kind != "" {
+			continue
+		}
+		k := a.Target
+		if count[k] > 1 {
+			k += " " + filepath.Base(a.Path)
+		}
+		out[k] = a
+	}
+	return out
+}
+
+func signedSize(n int64) string {
+	if n < 0 {
+		return "-" + humanSize(-n)
+	}
+	return "+" + humanSize(n)
+}
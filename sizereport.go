@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   --size-report: which packages make a binary big (go tool nm -size)
+   ------------------------------------------------------------------ */
+
+// pkgSize is the symbol size attributed to one package.
+type pkgSize struct {
+	Pkg  string
+	Size int64
+}
+
+// sizeReport prints the top packages by symbol size for every built job.
+func sizeReport(jobs []buildJob, top int, dry bool) error {
+	for _, j := range jobs {
+		if dry {
+			fmt.Fprintf(textOut, "\n# Dry-run: size report\ngo tool nm -size %s\n", j.Out)
+			continue
+		}
+		fi, err := os.Stat(j.Out)
+		if err != nil {
+			continue // not built (--keep-going)
+		}
+		sizes, err := packageSizes(j.Out)
+		if err != nil {
+			fmt.Fprintf(textOut, "\n%s: no size report: %v\n", j.label(), err)
+			continue
+		}
+		var total int64
+		for _, p := range sizes {
+			total += p.Size
+		}
+		fmt.Fprintf(textOut, "\n%s — %s (%s in symbols)\n", j.label(), humanSize(fi.Size()), humanSize(total))
+		for i, p := range sizes {
+			if i == top {
+				fmt.Fprintf(textOut, "  %10s  %5s  … %d more packages\n", "", "", len(sizes)-top)
+				break
+			}
+			fmt.Fprintf(textOut, "  %10s  %4.1f%%  %s\n", humanSize(p.Size), 100*float64(p.Size)/float64(total), p.Pkg)
+		}
+	}
+	return nil
+}
+
+// packageSizes sums `go tool nm -size` symbol sizes by package, largest
+// first.
+func packageSizes(bin string) ([]pkgSize, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(goBin, "tool", "nm", "-size", bin)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "no symbols") {
+			return nil, fmt.Errorf("binary is stripped (build without build.strip for a size report)")
+		}
+		return nil, fmt.Errorf("go tool nm: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	by := map[string]int64{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// address size type name; bss (B/b) and undefined (U) symbols
+		// take no space in the file
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || !strings.ContainsAny(f[2], "TtRrDd") {
+			continue
+		}
+		n, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil || n == 0 {
+			continue
+		}
+		by[symbolPackage(strings.Join(f[3:], " "))] += n
+	}
+	if len(by) == 0 {
+		return nil, fmt.Errorf("binary is stripped (build without build.strip for a size report)")
+	}
+	sizes := make([]pkgSize, 0, len(by))
+	for p, n := range by {
+		sizes = append(sizes, pkgSize{p, n})
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].Size > sizes[j].Size })
+	return sizes, nil
+}
+
+// symbolPackage maps a Go symbol name to its package path:
+// "github.com/a/b.(*T).M" → "github.com/a/b". Runtime type data and cgo
+// symbols get buckets of their own.
+func symbolPackage(sym string) string {
+	switch {
+	case strings.HasPrefix(sym, "type:"), strings.HasPrefix(sym, "go:itab"), strings.HasPrefix(sym, "type.."):
+		return "(type data)"
+	case strings.HasPrefix(sym, "go:"):
+		return "(go: data)" // string literals, build id, func info …
+	}
+	end := strings.IndexAny(sym, "([")
+	if end < 0 {
+		end = len(sym)
+	}
+	slash := strings.LastIndex(sym[:end], "/") + 1
+	dot := strings.Index(sym[slash:end], ".")
+	if dot < 0 {
+		return "(C and other)"
+	}
+	if pkg, err := url.PathUnescape(sym[:slash+dot]); err == nil {
+		return pkg // nm escapes dots in import paths: yaml%2ev3
+	}
+	return sym[:slash+dot]
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/* ------------------------------------------------------------------
+   Post-build smoke test, through qemu-user for foreign architectures
+   ------------------------------------------------------------------ */
+
+const (
+	defaultSmokeTest = "{{.Output}} --version"
+	smokeTimeout     = 2 * time.Minute
+)
+
+// qemuArch maps GOARCH to the qemu-user binary suffix.
+var qemuArch = map[string]string{
+	"386": "i386", "amd64": "x86_64", "arm": "arm", "arm64": "aarch64",
+	"ppc64": "ppc64", "ppc64le": "ppc64le", "riscv64": "riscv64", "s390x": "s390x",
+	"mips": "mips", "mipsle": "mipsel", "mips64": "mips64", "mips64le": "mips64el",
+	"loong64": "loongarch64",
+}
+
+// smokeCommand resolves the smoke_test setting: "" / false / off disable
+// it, true selects the default command, anything else is a template.
+func smokeCommand(s string) string {
+	switch s {
+	case "", "false", "off":
+		return ""
+	case "true":
+		return defaultSmokeTest
+	}
+	return s
+}
+
+// runSmokeTest renders tmpl for the built output and runs it through the
+// shell. Foreign linux binaries run through qemu-<arch> unless binfmt_misc
+// already handles them; binaries the host cannot run are skipped with a
+// warning.
+func runSmokeTest(tmpl string, j buildJob, w io.Writer, dry bool) error {
+	tp, err := template.New("smoke_test").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return fmt.Errorf("smoke_test: %w", err)
+	}
+	var b strings.Builder
+	err = tp.Execute(&b, struct{ Output, OS, Arch string }{shellQuote(j.Out), j.Target.OS, j.Target.Arch})
+	if err != nil {
+		return fmt.Errorf("smoke_test: %w", err)
+	}
+	line := b.String()
+
+	if j.Target.OS != runtime.GOOS || j.Target.Arch != runtime.GOARCH {
+		wrapped, ok := qemuWrap(line, j)
+		if !ok {
+			fmt.Fprintf(w, "⚠ smoke test skipped: cannot run %s binaries on %s/%s\n", j.label(), runtime.GOOS, runtime.GOARCH)
+			return nil
+		}
+		line = wrapped
+	}
+	if dry {
+		fmt.Fprintf(w, "# Dry-run: smoke test\n%s\n", line)
+		return nil
+	}
+
+	fmt.Fprintf(w, ">>> Smoke test %s\n", line)
+	ctx, cancel := context.WithTimeout(context.Background(), smokeTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", line)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", line)
+	}
+	cmd.Env = envSlice(j.Env)
+	cmd.Stdout, cmd.Stderr = w, stderrFor(w)
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", smokeTimeout)
+		}
+		return fmt.Errorf("smoke test %q: %w", line, err)
+	}
+	return nil
+}
+
+// qemuWrap prefixes line with qemu-<arch> when it starts with a foreign
+// linux binary; commands that do not execute the output run unchanged.
+func qemuWrap(line string, j buildJob) (string, bool) {
+	if !strings.HasPrefix(line, shellQuote(j.Out)) {
+		return line, true
+	}
+	if runtime.GOOS != "linux" || j.Target.OS != "linux" || qemuArch[j.Target.Arch] == "" {
+		return "", false
+	}
+	name := "qemu-" + qemuArch[j.Target.Arch]
+	if _, err := os.Stat("/proc/sys/fs/binfmt_misc/" + name); err == nil {
+		return line, true // the kernel runs it through qemu already
+	}
+	for _, bin := range []string{name, name + "-static"} {
+		if _, err := exec.LookPath(bin); err == nil {
+			return bin + " " + line, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/* ------------------------------------------------------------------
+   Packages: snaps for linux targets, built with snapcraft
+   ------------------------------------------------------------------ */
+
+// snapArch is the snap spelling of a GOARCH.
+var snapArch = map[string]string{
+	"amd64": "amd64", "arm64": "arm64", "arm": "armhf", "386": "i386",
+	"ppc64le": "ppc64el", "s390x": "s390x", "riscv64": "riscv64",
+}
+
+// snapcraftYAML is the snapcraft project of one binary: the prebuilt
+// binary is dumped into the snap and exposed as an app.
+type snapcraftYAML struct {
+	Name          string               `yaml:"name"`
+	Version       string               `yaml:"version"`
+	Summary       string               `yaml:"summary"`
+	Description   string               `yaml:"description"`
+	License       string               `yaml:"license,omitempty"`
+	Base          string               `yaml:"base"`
+	Grade         string               `yaml:"grade"`
+	Confinement   string               `yaml:"confinement"`
+	Architectures []snapBuild          `yaml:"architectures,omitempty"` // core22
+	Platforms     map[string]snapBuild `yaml:"platforms,omitempty"`     // core24 and later
+	Parts         map[string]snapPart  `yaml:"parts"`
+	Apps          map[string]snapApp   `yaml:"apps"`
+}
+
+type snapBuild struct {
+	BuildOn  []string `yaml:"build-on"`
+	BuildFor []string `yaml:"build-for"`
+}
+
+type snapPart struct {
+	Plugin string `yaml:"plugin"`
+	Source string `yaml:"source"`
+}
+
+type snapApp struct {
+	Command string   `yaml:"command"`
+	Plugs   []string `yaml:"plugs,omitempty"`
+}
+
+// snapProject describes the snap of job j, built on this host for the
+// target's architecture.
+func snapProject(cfg *Config, j buildJob) (snapcraftYAML, error) {
+	p, s := cfg.Packages, cfg.Packages.Snap
+	name, bin := firstNonEmpty(s.Name, packageName(cfg, j)), binaryName(j.Cfg)
+	desc := firstNonEmpty(s.Description, p.Description, name)
+	y := snapcraftYAML{
+		Name: name, Version: packageVersion(cfg), Summary: firstNonEmpty(s.Summary, p.Description, name),
+		Description: desc, License: p.License, Base: firstNonEmpty(s.Base, "core22"),
+		Grade: firstNonEmpty(s.Grade, "stable"), Confinement: firstNonEmpty(s.Confinement, "strict"),
+		Parts: map[string]snapPart{bin: {Plugin: "dump", Source: "files"}},
+		Apps:  map[string]snapApp{bin: {Command: "bin/" + bin, Plugs: s.Plugs}},
+	}
+	switch y.Grade {
+	case "stable", "devel":
+	default:
+		return y, fmt.Errorf("packages.snap.grade: want stable | devel, got %q", y.Grade)
+	}
+	switch y.Confinement {
+	case "strict", "classic", "devmode":
+	default:
+		return y, fmt.Errorf("packages.snap.confinement: want strict | classic | devmode, got %q", y.Confinement)
+	}
+	on, onOK := snapArch[runtime.GOARCH]
+	target, ok := snapArch[j.Target.Arch]
+	if !ok || !onOK || (j.Target.Arch == "arm" && j.Target.Variant != "" && strings.TrimPrefix(j.Target.Variant, "v") != "7") {
+		return y, fmt.Errorf("%s: packages.snap: no snap architecture for %s", j.label(), j.Target.Arch+j.Target.Variant)
+	}
+	switch y.Base {
+	case "core22":
+		y.Architectures = []snapBuild{{BuildOn: []string{on}, BuildFor: []string{target}}}
+	case "core18", "core20":
+		return y, fmt.Errorf("packages.snap.base: %s is not supported, use core22 or later", y.Base)
+	default:
+		y.Platforms = map[string]snapBuild{target: {BuildOn: []string{on}, BuildFor: []string{target}}}
+	}
+	return y, nil
+}
+
+// snapDir is the snapcraft project directory of job j, next to its
+// binary: snap/snapcraft.yaml and files/bin/<binary>.
+func snapDir(j buildJob) string {
+	return filepath.Join(filepath.Dir(j.Out), "snap-"+binaryName(j.Cfg))
+}
+
+// writeSnapProject writes the snapcraft project of job j.
+func writeSnapProject(cfg *Config, j buildJob) error {
+	y, err := snapProject(cfg, j)
+	if err != nil {
+		return err
+	}
+	dir := snapDir(j)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "snap"), 0o755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files", "bin"), 0o755); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(y)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snap", "snapcraft.yaml"), b, 0o644); err != nil {
+		return err
+	}
+	bin, err := os.ReadFile(j.Out)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "files", "bin", binaryName(j.Cfg)), bin, 0o755)
+}
+
+// buildSnaps writes a snapcraft project for every linux target and packs
+// it with snapcraft --destructive-mode, wherever go-builder runs: on the
+// host or, for docker builds, in the builder container. The .snap is
+// written next to the binary.
+func buildSnaps(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	if cfg.Packages.Snap == nil {
+		return nil
+	}
+	jobs = packageJobs(jobs, "linux")
+	if len(jobs) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: snapcraft")
+		for _, j := range jobs {
+			out, _ := filepath.Abs(snapFile(cfg, j))
+			fmt.Fprintf(textOut, "(cd %s && snapcraft pack --destructive-mode --output %s)\n", snapDir(j), out)
+		}
+		return nil
+	}
+	_, lookErr := exec.LookPath("snapcraft")
+
+	fmt.Fprintln(textOut, ">>> Snaps")
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		if err := writeSnapProject(cfg, j); err != nil {
+			return err
+		}
+		if lookErr != nil {
+			return fmt.Errorf("packages.snap: snapcraft not found in PATH; the project is in %s", snapDir(j))
+		}
+		out, err := filepath.Abs(snapFile(cfg, j))
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command("snapcraft", "pack", "--destructive-mode", "--output", out)
+		cmd.Dir = snapDir(j)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("snapcraft %s: %v: %s", j.label(), err, strings.TrimSpace(string(b)))
+		}
+		if err := recordPackage(m, j, "snap", snapFile(cfg, j)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapFile is name_version_arch.snap next to the binary of job j.
+func snapFile(cfg *Config, j buildJob) string {
+	name := firstNonEmpty(cfg.Packages.Snap.Name, packageName(cfg, j))
+	return filepath.Join(filepath.Dir(j.Out), fmt.Sprintf("%s_%s_%s.snap", name, packageVersion(cfg), snapArch[j.Target.Arch]))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   Test gate: `go test` must pass before any target is built
+   ------------------------------------------------------------------ */
+
+func runTestGate(cfg *Config, env []string, dry bool) error {
+	t := cfg.Test
+	args := []string{"test"}
+	if t.Race {
+		args = append(args, "-race")
+	}
+	if t.Timeout != "" {
+		args = append(args, "-timeout", t.Timeout)
+	}
+	args = append(args, t.Flags...)
+	pkgs := t.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	args = append(args, pkgs...)
+	if dry {
+		fmt.Fprintf(textOut, "\n# Dry-run: tests\ngo %s\n", strings.Join(args, " "))
+		return nil
+	}
+
+	fmt.Fprintln(textOut, ">>> Tests")
+	cmd := exec.Command(goBin, args...)
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = textOut, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("tests failed (use --skip-tests to build anyway): %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   go_version: download, verify and cache a Go release per version
+   ------------------------------------------------------------------ */
+
+// goBin is the go command used for builds, tests and benchmarks; it
+// becomes an absolute path when go_version selects a cached toolchain.
+var goBin = "go"
+
+const goDownloadIndex = "https://go.dev/dl/?mode=json&include=all"
+
+// ensureToolchain returns the root (GOROOT) of Go release version for
+// the host, downloading it into the toolchain cache on first use.
+func ensureToolchain(version string, offline, dry bool) (string, error) {
+	version = "go" + strings.TrimPrefix(version, "go")
+	root := filepath.Join(cacheDir("toolchains"), version)
+	goroot := filepath.Join(root, "go")
+	if _, err := os.Stat(filepath.Join(goroot, "bin", exeName("go"))); err == nil {
+		return goroot, nil
+	}
+	if dry {
+		fmt.Fprintf(textOut, "# Dry-run: download %s into %s\n", version, root)
+		return goroot, nil
+	}
+	if offline {
+		return "", fmt.Errorf("go_version %s is not cached and --offline forbids downloading it", version)
+	}
+
+	file, err := findRelease(version)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(textOut, ">>> Downloading %s\n", file.Filename)
+	tmp, err := os.CreateTemp("", "go-builder-go-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	resp, err := http.Get("https://go.dev/dl/" + file.Filename)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", file.Filename, resp.Status)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != file.SHA256 {
+		return "", fmt.Errorf("%s: sha256 mismatch: got %s, want %s", file.Filename, sum, file.SHA256)
+	}
+
+	// extract next to the final location, then rename into place
+	staging := root + ".partial"
+	os.RemoveAll(staging)
+	if strings.HasSuffix(file.Filename, ".zip") {
+		err = unzip(tmp.Name(), staging)
+	} else {
+		err = untarGz(tmp.Name(), staging)
+	}
+	if err == nil {
+		err = os.Rename(staging, root)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", fmt.Errorf("install %s: %w", version, err)
+	}
+	return goroot, nil
+}
+
+type goRelease struct {
+	Version string `json:"version"`
+	Files   []struct {
+		Filename string `json:"filename"`
+		OS       string `json:"os"`
+		Arch     string `json:"arch"`
+		SHA256   string `json:"sha256"`
+		Kind     string `json:"kind"`
+	} `json:"files"`
+}
+
+// findRelease looks up the host archive of version in the go.dev index.
+func findRelease(version string) (struct{ Filename, SHA256 string }, error) {
+	var file struct{ Filename, SHA256 string }
+	resp, err := http.Get(goDownloadIndex)
+	if err != nil {
+		return file, err
+	}
+	defer resp.Body.Close()
+	var releases []goRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return file, fmt.Errorf("go.dev release index: %w", err)
+	}
+	for _, r := range releases {
+		if r.Version != version {
+			continue
+		}
+		for _, f := range r.Files {
+			if f.OS == runtime.GOOS && f.Arch == runtime.GOARCH && f.Kind == "archive" {
+				file.Filename, file.SHA256 = f.Filename, f.SHA256
+				return file, nil
+			}
+		}
+		return file, fmt.Errorf("%s has no archive for %s/%s", version, runtime.GOOS, runtime.GOARCH)
+	}
+	return file, fmt.Errorf("unknown Go release %s", version)
+}
+
+// safeJoin joins name below dir, rejecting paths that escape it.
+func safeJoin(dir, name string) (string, error) {
+	p := filepath.Join(dir, name)
+	if !strings.HasPrefix(p, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %q escapes the target directory", name)
+	}
+	return p, nil
+}
+
+func untarGz(archive, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, 0o755)
+		case tar.TypeReg:
+			err = writeFile(p, tr, hdr.FileInfo().Mode().Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func unzip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		p, err := safeJoin(dir, zf.Name)
+		if err != nil {
+			return err
+		}
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(p, rc, zf.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func exeName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
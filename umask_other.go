@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "log"
+
+// setUmask is a no-op where the OS has no umask.
+func setUmask(s string) error {
+	log.Printf("go-builder: warning: output.umask is ignored on this platform")
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+// setUmask applies output.umask to the whole process.
+func setUmask(s string) error {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("output.umask %q: want an octal mask like 022", s)
+	}
+	syscall.Umask(int(m))
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+/* ------------------------------------------------------------------
+   WebAssembly targets (js/wasm, wasip1/wasm)
+   ------------------------------------------------------------------ */
+
+// copyWasmExec copies the JS glue for js/wasm from GOROOT next to out.
+func copyWasmExec(out string, env []string) error {
+	cmd := exec.Command(goBin, "env", "GOROOT")
+	cmd.Env = env
+	b, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("wasm_exec: go env GOROOT: %w", err)
+	}
+	goroot := strings.TrimSpace(string(b))
+	for _, rel := range []string{"lib/wasm/wasm_exec.js", "misc/wasm/wasm_exec.js"} { // Go 1.24+, older
+		src, err := os.Open(filepath.Join(goroot, filepath.FromSlash(rel)))
+		if err != nil {
+			continue
+		}
+		defer src.Close()
+		dst, err := os.Create(filepath.Join(filepath.Dir(out), "wasm_exec.js"))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	}
+	return fmt.Errorf("wasm_exec.js not found in %s", goroot)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/* ------------------------------------------------------------------
+   --watch: rebuild the host target whenever the source tree changes
+   ------------------------------------------------------------------ */
+
+// watchDebounce is how long the tree must be quiet before a rebuild.
+const watchDebounce = 300 * time.Millisecond
+
+// hostJobs returns the jobs building for the machine we run on.
+func hostJobs(jobs []buildJob) []buildJob {
+	var out []buildJob
+	for _, j := range jobs {
+		if j.Host || (j.Target.OS == runtime.GOOS && j.Target.Arch == runtime.GOARCH) {
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
+// watchBuild builds jobs now and again after every change below the
+// working directory, printing one status line per rebuild. run, if set,
+// is the binary started after each build: it is stopped before the build
+// replaces it (a running .exe is locked on Windows) and started again
+// afterwards, the previous build if this one failed.
+func watchBuild(cfg *Config, jobs []buildJob, base map[string]string, m *Manifest, dry bool, run *appRunner) error {
+	if len(jobs) == 0 {
+		return fmt.Errorf("--watch: no target for the host (%s/%s)", runtime.GOOS, runtime.GOARCH)
+	}
+	rebuild := func() {
+		if run != nil {
+			run.stop()
+		}
+		start := time.Now()
+		err := runJobs(jobs, base, m, 1, false, dry)
+		if err == nil && !dry {
+			err = m.save(cfg.BuildDir)
+		}
+		stamp := time.Now().Format("15:04:05")
+		if err != nil {
+			fmt.Fprintf(textOut, "%s ✘ build failed: %v\n", stamp, err)
+		} else {
+			fmt.Fprintf(textOut, "%s ✔ rebuilt %d target(s) in %s\n", stamp, len(jobs), time.Since(start).Round(time.Millisecond))
+		}
+		if _, serr := os.Stat(jobs[0].Out); run == nil || serr != nil {
+			return
+		}
+		if err := run.start(); err != nil {
+			fmt.Fprintf(os.Stderr, "go-builder: %v\n", err)
+		}
+	}
+	rebuild()
+	if dry {
+		fmt.Fprintln(textOut, "# Dry-run: would watch . and rebuild on changes")
+		return nil
+	}
+	return watchTree(".", []string{cfg.BuildDir}, func(changed []string) {
+		fmt.Fprintf(textOut, "%s changed: %s\n", time.Now().Format("15:04:05"), strings.Join(changed, ", "))
+		rebuild()
+	})
+}
+
+// watchTree calls fn with the changed paths once root has been quiet for
+// watchDebounce. Hidden entries, vendor/ and the skip directories are
+// ignored. It only returns when the watcher fails.
+func watchTree(root string, skip []string, fn func(changed []string)) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	ignored := func(p string) bool {
+		for _, s := range skip {
+			if rel, err := filepath.Rel(s, p); err == nil && !strings.HasPrefix(rel, "..") {
+				return true
+			}
+		}
+		name := filepath.Base(p)
+		return p != root && (strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || name == "vendor" || name == "node_modules")
+	}
+	addTree := func(dir string) error {
+		return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if ignored(p) {
+				return filepath.SkipDir
+			}
+			return w.Add(p)
+		})
+	}
+	if err := addTree(root); err != nil {
+		return err
+	}
+	fmt.Fprintf(textOut, "watching %s for changes (Ctrl-C to stop)\n", root)
+
+	changed := map[string]bool{}
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ignored(ev.Name) || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if ev.Op.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					addTree(ev.Name)
+				}
+			}
+			changed[filepath.ToSlash(ev.Name)] = true
+			timer.Reset(watchDebounce)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-timer.C:
+			fn(sortedKeys(changed))
+			clear(changed)
+		}
+	}
+}
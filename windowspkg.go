@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+/* ------------------------------------------------------------------
+   Packages: Windows installers for windows targets, built with NSIS
+   ------------------------------------------------------------------ */
+
+// nsisVersion is v as the four numeric fields VIProductVersion wants:
+// 1.2.3-rc.1 → 1.2.3.0.
+func nsisVersion(v string) string {
+	v, _, _ = strings.Cut(v, "-")
+	v, _, _ = strings.Cut(v, "+")
+	fields := strings.Split(v, ".")
+	for i, f := range fields {
+		if _, err := strconv.Atoi(f); err != nil || i == 4 {
+			fields = fields[:i]
+			break
+		}
+	}
+	for len(fields) < 4 {
+		fields = append(fields, "0")
+	}
+	return strings.Join(fields, ".")
+}
+
+// nsisQuote makes s a quoted NSIS string that expands no variables.
+func nsisQuote(s string) string {
+	s = strings.ReplaceAll(s, "$", "$$")
+	return `"` + strings.ReplaceAll(s, `"`, `$\"`) + `"`
+}
+
+// nsisScript is the installer: copy the binary to the install
+// directory, register an uninstaller in Apps & features and optionally
+// add a start menu shortcut. Values are already quoted with nsisQuote,
+// except InstallDir, which may use NSIS variables such as $PROGRAMFILES64.
+const nsisScript = `Unicode true
+Name {{.Name}}
+OutFile {{.Out}}
+InstallDir "{{.InstallDir}}"
+RequestExecutionLevel admin
+{{- if .Icon}}
+Icon {{.Icon}}
+UninstallIcon {{.Icon}}
+{{- end}}
+VIProductVersion {{.FileVersion}}
+VIAddVersionKey ProductName {{.Name}}
+VIAddVersionKey ProductVersion {{.Version}}
+VIAddVersionKey FileVersion {{.Version}}
+VIAddVersionKey CompanyName {{.Publisher}}
+VIAddVersionKey FileDescription {{.Description}}
+{{if .License}}
+LicenseData {{.License}}
+Page license
+{{- end}}
+Page directory
+Page instfiles
+UninstPage uninstConfirm
+UninstPage instfiles
+{{if .Wide}}
+Function .onInit
+  SetRegView 64
+FunctionEnd
+
+Function un.onInit
+  SetRegView 64
+FunctionEnd
+{{end}}
+Section
+  SetOutPath "$INSTDIR"
+  File /oname={{.Exe}} {{.Binary}}
+  WriteUninstaller "$INSTDIR\uninstall.exe"
+{{- if .Shortcut}}
+  CreateShortCut "$SMPROGRAMS\{{.Key}}.lnk" "$INSTDIR\{{.Exe}}"
+{{- end}}
+  WriteRegStr HKLM "{{.RegKey}}" DisplayName {{.Name}}
+  WriteRegStr HKLM "{{.RegKey}}" DisplayVersion {{.Version}}
+  WriteRegStr HKLM "{{.RegKey}}" Publisher {{.Publisher}}
+  WriteRegStr HKLM "{{.RegKey}}" DisplayIcon "$INSTDIR\{{.Exe}}"
+  WriteRegStr HKLM "{{.RegKey}}" UninstallString '"$INSTDIR\uninstall.exe"'
+  WriteRegDWORD HKLM "{{.RegKey}}" NoModify 1
+  WriteRegDWORD HKLM "{{.RegKey}}" NoRepair 1
+SectionEnd
+
+Section "Uninstall"
+  Delete "$INSTDIR\{{.Exe}}"
+  Delete "$INSTDIR\uninstall.exe"
+{{- if .Shortcut}}
+  Delete "$SMPROGRAMS\{{.Key}}.lnk"
+{{- end}}
+  RMDir "$INSTDIR"
+  DeleteRegKey HKLM "{{.RegKey}}"
+SectionEnd
+`
+
+var nsisTemplate = template.Must(template.New("nsis").Parse(nsisScript))
+
+// nsisVars are the fields of nsisScript.
+type nsisVars struct {
+	Name, Version, FileVersion, Publisher, Description string
+	Out, Binary, Icon, License                         string
+	InstallDir, Exe, Key, RegKey                       string
+	Shortcut, Wide                                     bool
+}
+
+// installerFile is where the installer of job j goes: next to the .exe.
+func installerFile(cfg *Config, j buildJob) string {
+	return filepath.Join(filepath.Dir(j.Out), fmt.Sprintf("%s_%s_%s_setup.exe", packageName(cfg, j), packageVersion(cfg), j.Target.Arch))
+}
+
+// nsisVarsFor describes the installer of job j.
+func nsisVarsFor(cfg *Config, j buildJob) (nsisVars, error) {
+	p, w := cfg.Packages, cfg.Packages.Windows
+	key := packageName(cfg, j)
+	name := firstNonEmpty(w.Name, key)
+	v := nsisVars{
+		Name: nsisQuote(name), Version: nsisQuote(packageVersion(cfg)), FileVersion: nsisVersion(packageVersion(cfg)),
+		Publisher: nsisQuote(firstNonEmpty(w.Publisher, p.Maintainer)), Description: nsisQuote(firstNonEmpty(p.Description, name)),
+		Exe: strings.TrimSuffix(binaryName(j.Cfg), ".exe") + ".exe", Key: key,
+		RegKey:   `Software\Microsoft\Windows\CurrentVersion\Uninstall\` + key,
+		Shortcut: w.Shortcut, Wide: j.Target.Arch != "386",
+	}
+	programFiles := "$PROGRAMFILES64"
+	if !v.Wide {
+		programFiles = "$PROGRAMFILES"
+	}
+	v.InstallDir = firstNonEmpty(w.InstallDir, programFiles+`\`+name)
+	abs := func(f string) (string, error) {
+		if f == "" {
+			return "", nil
+		}
+		a, err := filepath.Abs(f)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(a); err != nil {
+			return "", fmt.Errorf("packages.windows: %w", err)
+		}
+		return nsisQuote(a), nil
+	}
+	var err error
+	if v.Binary, err = abs(j.Out); err != nil {
+		return v, err
+	}
+	if v.Icon, err = abs(w.Icon); err != nil {
+		return v, err
+	}
+	if v.License, err = abs(w.LicenseFile); err != nil {
+		return v, err
+	}
+	out, err := filepath.Abs(installerFile(cfg, j))
+	if err != nil {
+		return v, err
+	}
+	v.Out = nsisQuote(out)
+	return v, nil
+}
+
+// buildWindowsInstallers runs makensis for every windows target, writing
+// name_version_arch_setup.exe next to the binary.
+func buildWindowsInstallers(cfg *Config, jobs []buildJob, m *Manifest, dry bool) error {
+	jobs = packageJobs(jobs, "windows")
+	if cfg.Packages.Windows == nil || len(jobs) == 0 {
+		return nil
+	}
+	if dry {
+		fmt.Fprintln(textOut, "\n# Dry-run: NSIS")
+		for _, j := range jobs {
+			fmt.Fprintf(textOut, "makensis -V2 <script> → %s\n", installerFile(cfg, j))
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("makensis"); err != nil {
+		return fmt.Errorf("packages.windows: makensis not found in PATH (install nsis)")
+	}
+
+	fmt.Fprintln(textOut, ">>> Windows installers")
+	for _, j := range jobs {
+		if _, err := os.Stat(j.Out); err != nil {
+			continue // not built (--keep-going)
+		}
+		v, err := nsisVarsFor(cfg, j)
+		if err != nil {
+			return err
+		}
+		if err := runMakensis(v); err != nil {
+			return fmt.Errorf("makensis %s: %w", j.label(), err)
+		}
+		if err := recordPackage(m, j, "nsis", installerFile(cfg, j)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMakensis writes the script for v to a temporary file and compiles it.
+func runMakensis(v nsisVars) error {
+	f, err := os.CreateTemp("", "go-builder-*.nsi")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = nsisTemplate.Execute(f, v)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("makensis", "-V2", f.Name())
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(b)))
+	}
+	return nil
+}